@@ -1,27 +1,58 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"image/color"
 	"log"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 const (
 	screenWidth  = 640
 	screenHeight = 480
-	blurSize     = 30.0 // ぼかしの強度をここで調整
+	blurSize     = 30.0 // ぼかしの強度の初期値
+	readoutTicks = 90   // ぼかしの強度を画面に表示しておくティック数
 )
 
-var (
-	gopherImage   *ebiten.Image
-	ambientShader *ebiten.Shader
-)
+var ambientShader *ebiten.Shader
+
+type Game struct {
+	source      frameSource // ぼかしの対象となる画像
+	blurSize    float64     // 現在のぼかしの強度
+	blurPresets []float64   // 数字キー1から順に割り当てるぼかしの強度
+	readout     int         // ぼかしの強度を表示する残りティック数
+}
 
-type Game struct{}
+// Statically check that *Game implements ebiten.Game.
+var _ ebiten.Game = (*Game)(nil)
+
+// NewGame は source をぼかして表示するゲームを作成する
+func NewGame(source frameSource) *Game {
+	return &Game{
+		source:      source,
+		blurSize:    blurSize,
+		blurPresets: []float64{0, 10, 30, 60, 100},
+	}
+}
 
 func (g *Game) Update() error {
+	// 数字キーでプリセットのぼかしの強度に切り替える
+	for i, preset := range g.blurPresets {
+		if i > 8 {
+			break
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyDigit1 + ebiten.Key(i)) {
+			g.blurSize = preset
+			g.readout = readoutTicks
+		}
+	}
+	if g.readout > 0 {
+		g.readout--
+	}
 	return nil
 }
 
@@ -32,10 +63,11 @@ func (g *Game) Draw(screen *ebiten.Image) {
 	// 描画ターゲットとなる中間画像を作成
 	tmpImg := ebiten.NewImage(screenWidth, screenHeight)
 
-	// gopher.pngを中間画像の中央に描画
+	// 現在のフレームを中間画像の中央に描画
+	srcImage := g.source.Frame()
 	op := &ebiten.DrawImageOptions{}
-	op.GeoM.Translate(float64(screenWidth)/2-float64(gopherImage.Bounds().Dx())/2, float64(screenHeight)/2-float64(gopherImage.Bounds().Dy())/2)
-	tmpImg.DrawImage(gopherImage, op)
+	op.GeoM.Translate(float64(screenWidth)/2-float64(srcImage.Bounds().Dx())/2, float64(screenHeight)/2-float64(srcImage.Bounds().Dy())/2)
+	tmpImg.DrawImage(srcImage, op)
 
 	// Kageシェーダーを適用するための描画オプション
 	shaderOp := &ebiten.DrawRectShaderOptions{}
@@ -43,7 +75,7 @@ func (g *Game) Draw(screen *ebiten.Image) {
 
 	// Kageシェーダーにユニフォーム変数を渡す
 	shaderOp.Uniforms = map[string]any{
-		"BlurSize": blurSize,
+		"BlurSize": g.blurSize,
 	}
 
 	// Kageシェーダーを使って、tmpImgをscreenに描画
@@ -51,7 +83,12 @@ func (g *Game) Draw(screen *ebiten.Image) {
 
 	// オリジナルの画像をぼかした背景の上に描画
 	// これにより、アンビエント効果が完成する
-	screen.DrawImage(gopherImage, op)
+	screen.DrawImage(srcImage, op)
+
+	// 切り替えた直後だけ、ぼかしの強度を表示する
+	if g.readout > 0 {
+		ebitenutil.DebugPrint(screen, fmt.Sprintf("Blur: %g", g.blurSize))
+	}
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
@@ -59,17 +96,31 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 }
 
 func main() {
-	var err error
-	gopherImage, _, err = ebitenutil.NewImageFromFile("gopher.png")
-	if err != nil {
-		log.Fatal(err)
+	// キャプチャツールが書き出す画像ファイルを指定すると、更新に追従してぼかす
+	capturePath := flag.String("capture", "", "Blur this image file, reloading it whenever it changes (e.g. a file written by a screen or webcam capture tool).")
+	flag.Parse()
+
+	var source frameSource
+	if *capturePath != "" {
+		fs, err := newFileSource(*capturePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = fs
+	} else {
+		gopherImage, _, err := ebitenutil.NewImageFromFile("gopher.png")
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = &staticSource{img: gopherImage}
 	}
 
 	ambientShader = loadShader("ambient.kage")
 
 	ebiten.SetWindowSize(screenWidth, screenHeight)
 	ebiten.SetWindowTitle("Ambient Mode Example")
-	if err := ebiten.RunGame(&Game{}); err != nil {
+	game := NewGame(source)
+	if err := ebiten.RunGame(game); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestNewGame(t *testing.T) {
+	source := &staticSource{}
+	g := NewGame(source)
+	if g == nil {
+		t.Fatal("NewGame returned nil")
+	}
+	if g.source != source {
+		t.Error("source is not set")
+	}
+	if g.blurSize != blurSize {
+		t.Errorf("blurSize = %g, want %g", g.blurSize, blurSize)
+	}
+	if len(g.blurPresets) == 0 {
+		t.Error("no blur presets")
+	}
+}
+
+// ぼかしの強度の表示は Update のたびに減り、0 で止まる
+func TestUpdateCountsDownReadout(t *testing.T) {
+	g := NewGame(&staticSource{})
+	g.readout = 2
+	for range 3 {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.readout != 0 {
+		t.Errorf("readout = %d, want 0", g.readout)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// ファイルの更新を確認する間隔
+const reloadInterval = 100 * time.Millisecond
+
+// frameSource はぼかしの対象となる画像を提供する
+// Draw は画像の出どころを気にせず、毎フレーム Frame を呼ぶだけでよい
+type frameSource interface {
+	Frame() *ebiten.Image
+}
+
+// staticSource は常に同じ画像を返す
+type staticSource struct {
+	img *ebiten.Image
+}
+
+func (s *staticSource) Frame() *ebiten.Image {
+	return s.img
+}
+
+// fileSource は画像ファイルを監視し、更新されるたびに読み込み直す
+// 画面やWebカメラのキャプチャツールが書き出すファイルを指定すると、
+// その映像をぼかしの対象にできる
+type fileSource struct {
+	path      string
+	img       *ebiten.Image
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newFileSource(path string) (*fileSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := ebitenutil.NewImageFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSource{path: path, img: img, modTime: info.ModTime(), checkedAt: time.Now()}, nil
+}
+
+func (s *fileSource) Frame() *ebiten.Image {
+	if time.Since(s.checkedAt) < reloadInterval {
+		return s.img
+	}
+	s.checkedAt = time.Now()
+
+	info, err := os.Stat(s.path)
+	if err != nil || info.ModTime().Equal(s.modTime) {
+		return s.img
+	}
+	img, _, err := ebitenutil.NewImageFromFile(s.path)
+	if err != nil {
+		// 書き込み途中のファイルは読めないことがあるので、前の画像を使い続ける
+		log.Printf("%s の読み込みに失敗しました: %v", s.path, err)
+		return s.img
+	}
+	s.img.Deallocate()
+	s.img = img
+	s.modTime = info.ModTime()
+	return s.img
+}
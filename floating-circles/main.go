@@ -1,105 +1,296 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"image"
 	"image/color"
 	"log"
 	"math"
 	"math/rand"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
 
 const (
-	// maxCircles is the maximum number of circles on screen.
-	maxCircles = 50
+	// defaultMaxCircles is the maximum number of circles on screen unless -max is given.
+	defaultMaxCircles = 50
+	// spawnChancePerCircle is the chance per tick of spawning a circle, per circle
+	// allowed on screen: 50 circles spawn one roughly every 1/3 second.
+	spawnChancePerCircle = 1.0 / (20 * defaultMaxCircles)
 	// minLifetime and maxLifetime define the random range of a circle's life in ticks (60 ticks = 1 second).
 	minLifetime = 300 // 5 seconds
 	maxLifetime = 900 // 15 seconds
+	// linkDistance is the maximum distance between two circles connected by a line.
+	linkDistance = 150.0
+	// maxRestitution is the largest accepted -restitution value.
+	maxRestitution = 1.2
+	// maxBounceSpeed caps the speed of circles sped up by a restitution above 1
+	// or by the cursor.
+	maxBounceSpeed = 4.0
+	// minBounceSpeed is the slowest a circle leaves a wall with, so one slowed
+	// down by a restitution below 1 never stops inside the window for good.
+	minBounceSpeed = 0.25
+	// repelRadius is the distance from the cursor within which circles are pushed away.
+	repelRadius = 100.0
+	// repelStrength scales the push, which is repelStrength/distance per tick.
+	repelStrength = 5.0
+	// particlesPerBurst is the number of particles a circle bursts into when its lifetime ends.
+	particlesPerBurst = 12
+	// particleLifetime is how many ticks a particle lives.
+	particleLifetime = 30
+	// maxParticles caps the particles alive at once, so a mass die-off stays cheap.
+	maxParticles = 300
+	// trailLength is the number of past positions drawn behind each circle.
+	trailLength = 8
+	// gravity is the downward acceleration of circles in gravity mode, per tick.
+	gravity = 0.05
+	// floorDamping is the share of the vertical speed kept when a circle in
+	// gravity mode bounces off the bottom wall, so it settles over time.
+	floorDamping = 0.8
+	// mixedShapes spawns squares and triangles along with circles. Set it to
+	// false to draw only circles.
+	mixedShapes = true
 )
 
+// Shape is the outline an object is drawn with. Its radius stays the radius of
+// the bounding circle, so bouncing and collisions treat every shape as a circle.
+type Shape int
+
+const (
+	shapeCircle Shape = iota
+	shapeSquare
+	shapeTriangle
+	shapeCount // Number of shapes, for picking one at random
+)
+
+var (
+	// whiteImage is the source image of the shapes drawn by drawShape.
+	whiteImage = ebiten.NewImage(3, 3)
+	// whiteSubImage is the center pixel of whiteImage, away from its edges.
+	whiteSubImage = whiteImage.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+)
+
+func init() {
+	whiteImage.Fill(color.White)
+}
+
 // Circle represents a single circle object.
 type Circle struct {
 	x, y     float64 // Position
 	vx, vy   float64 // Velocity
 	radius   float64
 	lifetime int
+	col      color.RGBA // Premultiplied, so the alpha channel is kept when drawing
+	shape    Shape
+
+	trail     [trailLength]trailPoint // Ring buffer of recent positions, oldest at trailHead
+	trailHead int
+}
+
+// Particle is a dot of the burst a circle gives off when its lifetime ends.
+type Particle struct {
+	x, y, vx, vy float64
+	life         int        // Remaining ticks
+	col          color.RGBA // Color of the circle it came from
+}
+
+// trailPoint is a past position of a Circle.
+type trailPoint struct {
+	x, y float64
 }
 
 // Game implements ebiten.Game interface.
 type Game struct {
-	circles []*Circle
+	circles     []*Circle
+	particles   []*Particle
+	lines       bool         // Connect nearby circles with lines (constellation effect)
+	restitution float64      // Share of the speed kept on each bounce
+	ringWidth   float32      // Stroke width of circles drawn as rings, 0 draws filled circles
+	palette     []color.RGBA // Colors picked for new circles, empty draws every circle white
+	rng         *rand.Rand   // Source of all randomness, so a seed reproduces a run
+	maxCircles  int          // Maximum number of circles on screen
+	debug       bool         // Show the frame rate and circle count, toggled with F3
+	gravity     bool         // Pull circles down instead of letting them drift straight
+	paused      bool         // Freeze the simulation, toggled with space; period steps one tick
+
+	blend    ebiten.Blend // Blend mode of the shapes, toggled between normal and additive with B
+	vertices []ebiten.Vertex
+	indices  []uint16
+
+	width, height         int // Logical screen size from the last Layout call
+	prevWidth, prevHeight int // Screen size used by the previous Update, to notice shrinking
 }
 
-// NewGame initializes the game state.
+// Statically check that *Game implements ebiten.Game.
+var _ ebiten.Game = (*Game)(nil)
+
+// NewGame initializes the game state with a random seed.
 func NewGame() *Game {
-	// As of Go 1.20, seeding the global random number generator is not necessary.
+	return NewGameWithSeed(time.Now().UnixNano())
+}
+
+// NewGameWithSeed initializes the game state so that the same seed always
+// produces the same sequence of spawns.
+func NewGameWithSeed(seed int64) *Game {
 	return &Game{
-		circles: []*Circle{},
+		circles:     []*Circle{},
+		restitution: 1,
+		rng:         rand.New(rand.NewSource(seed)),
+		maxCircles:  defaultMaxCircles,
+		blend:       ebiten.BlendSourceOver,
+	}
+}
+
+// pickColor returns a random color from palette, with every entry equally
+// likely, or opaque white if palette is empty.
+func pickColor(rng *rand.Rand, palette []color.RGBA) color.RGBA {
+	if len(palette) == 0 {
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}
+	}
+	return palette[rng.Intn(len(palette))]
+}
+
+// parsePalette parses a comma-separated list of colors given as RRGGBB or
+// RRGGBBAA hex, each optionally prefixed with #. Colors with alpha are
+// premultiplied, as Circle expects.
+func parsePalette(s string) ([]color.RGBA, error) {
+	var palette []color.RGBA
+	for _, entry := range strings.Split(s, ",") {
+		hex := strings.TrimPrefix(strings.TrimSpace(entry), "#")
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 8 || err != nil {
+			return nil, fmt.Errorf("invalid color %q: want RRGGBB or RRGGBBAA", entry)
+		}
+		c := color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+		palette = append(palette, color.RGBAModel.Convert(c).(color.RGBA))
 	}
+	return palette, nil
 }
 
 // spawnCircle creates a new circle at a random screen edge and gives it a velocity.
 func (g *Game) spawnCircle(screenWidth, screenHeight int) {
-	if len(g.circles) >= maxCircles {
+	if len(g.circles) >= g.maxCircles {
 		return
 	}
 
-	radius := 5.0 + rand.Float64()*15.0 // Random radius between 5 and 20
+	radius := 5.0 + g.rng.Float64()*15.0 // Random radius between 5 and 20
 	var x, y float64
-	edge := rand.Intn(4)
+	edge := g.rng.Intn(4)
 
 	// Determine starting position based on a random edge.
 	switch edge {
 	case 0: // Top edge
-		x = rand.Float64() * float64(screenWidth)
+		x = g.rng.Float64() * float64(screenWidth)
 		y = -radius
 	case 1: // Right edge
 		x = float64(screenWidth) + radius
-		y = rand.Float64() * float64(screenHeight)
+		y = g.rng.Float64() * float64(screenHeight)
 	case 2: // Bottom edge
-		x = rand.Float64() * float64(screenWidth)
+		x = g.rng.Float64() * float64(screenWidth)
 		y = float64(screenHeight) + radius
 	case 3: // Left edge
 		x = -radius
-		y = rand.Float64() * float64(screenHeight)
+		y = g.rng.Float64() * float64(screenHeight)
 	}
 
 	// Give it a random velocity, generally directed towards the screen.
 	angle := math.Atan2(float64(screenHeight/2)-y, float64(screenWidth/2)-x)
-	angle += (rand.Float64() - 0.5) * (math.Pi / 2) // Add some random deviation
-	speed := 0.5 + rand.Float64()*1.5               // Random speed between 0.5 and 2.0
+	angle += (g.rng.Float64() - 0.5) * (math.Pi / 2) // Add some random deviation
+	speed := 0.5 + g.rng.Float64()*1.5               // Random speed between 0.5 and 2.0
 
-	g.circles = append(g.circles, &Circle{
+	shape := shapeCircle
+	if mixedShapes {
+		shape = Shape(g.rng.Intn(int(shapeCount)))
+	}
+
+	c := &Circle{
 		x:        x,
 		y:        y,
 		vx:       math.Cos(angle) * speed,
 		vy:       math.Sin(angle) * speed,
 		radius:   radius,
-		lifetime: minLifetime + rand.Intn(maxLifetime-minLifetime),
-	})
+		lifetime: minLifetime + g.rng.Intn(maxLifetime-minLifetime),
+		col:      pickColor(g.rng, g.palette),
+		shape:    shape,
+	}
+	// Start the trail at the spawn point so it doesn't reach back to the origin.
+	for i := range c.trail {
+		c.trail[i] = trailPoint{x, y}
+	}
+	g.circles = append(g.circles, c)
 }
 
 // Update proceeds the game state.
 func (g *Game) Update() error {
-	w, h := ebiten.WindowSize()
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		g.paused = !g.paused
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		g.debug = !g.debug
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		if g.blend == ebiten.BlendLighter {
+			g.blend = ebiten.BlendSourceOver
+		} else {
+			g.blend = ebiten.BlendLighter
+		}
+	}
+	// While paused, only the period key advances the simulation, by one tick.
+	if g.paused && !inpututil.IsKeyJustPressed(ebiten.KeyPeriod) {
+		return nil
+	}
 
-	// Spawn a new circle periodically.
-	if len(g.circles) < maxCircles && rand.Intn(20) == 0 { // Spawn roughly every 1/3 second.
+	// Use the size of the logical screen from the last Layout, so bouncing and
+	// culling agree with what is drawn while the window is being resized.
+	w, h := g.width, g.height
+	shrunk := w < g.prevWidth || h < g.prevHeight
+	g.prevWidth, g.prevHeight = w, h
+
+	// Spawn a new circle now and then, more often the more circles are allowed,
+	// so the screen fills up in about the same time.
+	if len(g.circles) < g.maxCircles && g.rng.Float64() < float64(g.maxCircles)*spawnChancePerCircle {
 		g.spawnCircle(w, h)
 	}
 
+	g.repelFromCursor()
+
 	// Use a new slice to store circles for the next frame.
 	// This is an easy way to remove circles from the slice while iterating.
 	nextCircles := make([]*Circle, 0, len(g.circles))
 
 	for _, c := range g.circles {
-		// Move the circle.
+		// Remember the position for the trail, then move the circle.
+		c.trail[c.trailHead] = trailPoint{c.x, c.y}
+		c.trailHead = (c.trailHead + 1) % trailLength
+		if g.gravity {
+			c.vy += gravity
+		}
 		c.x += c.vx
 		c.y += c.vy
 		c.lifetime--
 
 		isOutside := c.x+c.radius < 0 || c.x-c.radius > float64(w) || c.y+c.radius < 0 || c.y-c.radius > float64(h)
+		if shrunk && isOutside {
+			// Left behind by a shrinking window. It is expired rather than bounced
+			// back in, and since nothing outside the screen is drawn, culling it
+			// now looks the same as letting it drift off.
+			c.lifetime = -1
+		}
+
+		// Burst as the lifetime runs out, while the circle can still be seen.
+		if c.lifetime == 0 && !isOutside {
+			g.burst(c)
+		}
 
 		// If lifetime is over and the circle is completely outside the screen, remove it.
 		if c.lifetime < 0 && isOutside {
@@ -108,40 +299,312 @@ func (g *Game) Update() error {
 
 		// If lifetime is active, bounce off the walls.
 		if c.lifetime >= 0 {
-			if (c.vx < 0 && c.x-c.radius < 0) || (c.vx > 0 && c.x+c.radius > float64(w)) {
-				c.vx *= -1
-			}
-			if (c.vy < 0 && c.y-c.radius < 0) || (c.vy > 0 && c.y+c.radius > float64(h)) {
-				c.vy *= -1
+			c.vx = bounceAxis(c.x, c.vx, c.radius, 0, float64(w), g.restitution)
+			vy := bounceAxis(c.y, c.vy, c.radius, 0, float64(h), g.restitution)
+			if g.gravity && c.vy > 0 && vy < 0 {
+				// Bounced off the bottom wall. Unlike other bounces this has no
+				// minBounceSpeed floor, so the circle loses energy and settles.
+				vy = -min(c.vy*g.restitution, maxBounceSpeed) * floorDamping
 			}
+			c.vy = vy
 		}
 		nextCircles = append(nextCircles, c)
 	}
 	g.circles = nextCircles
+	g.resolveCollisions()
+
+	nextParticles := g.particles[:0]
+	for _, p := range g.particles {
+		p.x += p.vx
+		p.y += p.vy
+		p.life--
+		if p.life > 0 {
+			nextParticles = append(nextParticles, p)
+		}
+	}
+	g.particles = nextParticles
 
 	return nil
 }
 
+// repelFromCursor accelerates circles near the mouse cursor away from it, more
+// strongly the closer they are, up to maxBounceSpeed. Clicks pass through the
+// window, but the cursor position can still be read. Circles past their
+// lifetime are not pushed, so they can still drift off the screen.
+func (g *Game) repelFromCursor() {
+	mx, my := ebiten.CursorPosition()
+	for _, c := range g.circles {
+		if c.lifetime < 0 {
+			continue
+		}
+		dx, dy := c.x-float64(mx), c.y-float64(my)
+		dist := math.Hypot(dx, dy)
+		if dist >= repelRadius || dist == 0 {
+			continue
+		}
+		push := repelStrength / dist
+		c.vx += dx / dist * push
+		c.vy += dy / dist * push
+		if speed := math.Hypot(c.vx, c.vy); speed > maxBounceSpeed {
+			c.vx *= maxBounceSpeed / speed
+			c.vy *= maxBounceSpeed / speed
+		}
+	}
+}
+
+// burst scatters particles in the color of c from its position, as long as
+// fewer than maxParticles are alive.
+func (g *Game) burst(c *Circle) {
+	for i := 0; i < particlesPerBurst && len(g.particles) < maxParticles; i++ {
+		angle := g.rng.Float64() * 2 * math.Pi
+		speed := 0.5 + g.rng.Float64()*1.5
+		g.particles = append(g.particles, &Particle{
+			x:    c.x,
+			y:    c.y,
+			vx:   math.Cos(angle) * speed,
+			vy:   math.Sin(angle) * speed,
+			life: particleLifetime,
+			col:  c.col,
+		})
+	}
+}
+
+// resolveCollisions bounces overlapping circles off each other elastically,
+// using their radii as masses, and pushes them apart so they don't stick.
+// Circles past their lifetime are left alone so they can drift off the screen.
+// Like drawLinks, this is O(n^2), which is cheap for the usual number of circles.
+func (g *Game) resolveCollisions() {
+	for i, a := range g.circles {
+		if a.lifetime < 0 {
+			continue
+		}
+		for _, b := range g.circles[i+1:] {
+			if b.lifetime < 0 {
+				continue
+			}
+			dx, dy := b.x-a.x, b.y-a.y
+			dist := math.Hypot(dx, dy)
+			overlap := a.radius + b.radius - dist
+			if overlap <= 0 {
+				continue
+			}
+			nx, ny := 1.0, 0.0 // Any direction will do for circles at the same spot
+			if dist > 0 {
+				nx, ny = dx/dist, dy/dist
+			}
+			ma, mb := a.radius, b.radius
+
+			// Exchange momentum along the normal, unless they are already separating.
+			if approach := (b.vx-a.vx)*nx + (b.vy-a.vy)*ny; approach < 0 {
+				j := 2 * approach / (ma + mb)
+				a.vx += j * mb * nx
+				a.vy += j * mb * ny
+				b.vx -= j * ma * nx
+				b.vy -= j * ma * ny
+			}
+
+			// Move them out of each other, the lighter one further.
+			a.x -= overlap * mb / (ma + mb) * nx
+			a.y -= overlap * mb / (ma + mb) * ny
+			b.x += overlap * ma / (ma + mb) * nx
+			b.y += overlap * ma / (ma + mb) * ny
+		}
+	}
+}
+
+// bounce reverses the velocity component v, scaled by restitution. Speeds gained
+// from a restitution above 1 are capped at maxBounceSpeed, and speeds lost to a
+// restitution below 1 stop at minBounceSpeed. A restitution of 1 only reverses v.
+func bounce(v, restitution float64) float64 {
+	v *= -restitution
+	if restitution != 1 && math.Abs(v) < minBounceSpeed {
+		v = math.Copysign(minBounceSpeed, v)
+	}
+	return max(min(v, maxBounceSpeed), -maxBounceSpeed)
+}
+
+// bounceAxis bounces v if a circle at pos is heading out of [lo, hi] along one
+// axis. A circle wider than the range swings between the walls instead.
+func bounceAxis(pos, v, radius, lo, hi, restitution float64) float64 {
+	minPos, maxPos := lo+radius, hi-radius
+	if minPos > maxPos {
+		minPos, maxPos = maxPos, minPos
+	}
+	if (v < 0 && pos < minPos) || (v > 0 && pos > maxPos) {
+		return bounce(v, restitution)
+	}
+	return v
+}
+
 // Draw draws the game screen.
 func (g *Game) Draw(screen *ebiten.Image) {
 	// The screen is automatically cleared because we have set SetScreenTransparent(true).
+	// Links go first so the circles cover the ends of the lines.
+	if g.lines {
+		g.drawLinks(screen)
+	}
+
 	for _, c := range g.circles {
-		// Use ebiten/vector package to draw a circle.
-		vector.DrawFilledCircle(screen, float32(c.x), float32(c.y), float32(c.radius), color.White, true)
+		// Draw the trail from oldest to newest, growing and fading in towards the circle.
+		for i := 0; i < trailLength; i++ {
+			p := c.trail[(c.trailHead+i)%trailLength]
+			t := float32(i+1) / float32(trailLength+1)
+			clr := color.RGBA{
+				R: uint8(float32(c.col.R) * t * 0.5),
+				G: uint8(float32(c.col.G) * t * 0.5),
+				B: uint8(float32(c.col.B) * t * 0.5),
+				A: uint8(float32(c.col.A) * t * 0.5),
+			}
+			g.drawShape(screen, c.shape, float32(p.x), float32(p.y), float32(c.radius)*t, clr)
+		}
+		g.drawShape(screen, c.shape, float32(c.x), float32(c.y), float32(c.radius), c.col)
+	}
+
+	// Particles are 2px dots that fade out over their lifetime.
+	for _, p := range g.particles {
+		t := float32(p.life) / particleLifetime
+		clr := color.RGBA{
+			R: uint8(float32(p.col.R) * t),
+			G: uint8(float32(p.col.G) * t),
+			B: uint8(float32(p.col.B) * t),
+			A: uint8(float32(p.col.A) * t),
+		}
+		vector.DrawFilledRect(screen, float32(p.x)-1, float32(p.y)-1, 2, 2, clr, false)
+	}
+
+	if g.debug {
+		ebitenutil.DebugPrint(screen, fmt.Sprintf("FPS: %.1f\nTPS: %.1f\nCircles: %d", ebiten.ActualFPS(), ebiten.ActualTPS(), len(g.circles)))
+	}
+}
+
+// drawShape draws shape inscribed in the circle of radius r around (x, y), filled
+// or as an outline of ringWidth, with the game's blend mode.
+func (g *Game) drawShape(screen *ebiten.Image, shape Shape, x, y, r float32, clr color.RGBA) {
+	var path vector.Path
+	switch shape {
+	case shapeSquare:
+		half := r / math.Sqrt2
+		path.MoveTo(x-half, y-half)
+		path.LineTo(x+half, y-half)
+		path.LineTo(x+half, y+half)
+		path.LineTo(x-half, y+half)
+	case shapeTriangle:
+		// An equilateral triangle pointing up.
+		for i := 0; i < 3; i++ {
+			angle := -math.Pi/2 + float64(i)*2*math.Pi/3
+			px, py := x+r*float32(math.Cos(angle)), y+r*float32(math.Sin(angle))
+			if i == 0 {
+				path.MoveTo(px, py)
+			} else {
+				path.LineTo(px, py)
+			}
+		}
+	default:
+		path.Arc(x, y, r, 0, 2*math.Pi, vector.Clockwise)
+	}
+	path.Close()
+
+	// Reuse the vertex buffers between calls, as the vector package does.
+	if g.ringWidth > 0 {
+		g.vertices, g.indices = path.AppendVerticesAndIndicesForStroke(g.vertices[:0], g.indices[:0], &vector.StrokeOptions{Width: g.ringWidth, LineJoin: vector.LineJoinMiter})
+	} else {
+		g.vertices, g.indices = path.AppendVerticesAndIndicesForFilling(g.vertices[:0], g.indices[:0])
+	}
+	for i := range g.vertices {
+		v := &g.vertices[i]
+		v.SrcX, v.SrcY = 1, 1
+		v.ColorR = float32(clr.R) / 0xff
+		v.ColorG = float32(clr.G) / 0xff
+		v.ColorB = float32(clr.B) / 0xff
+		v.ColorA = float32(clr.A) / 0xff
+	}
+	op := &ebiten.DrawTrianglesOptions{
+		AntiAlias:      true,
+		ColorScaleMode: ebiten.ColorScaleModePremultipliedAlpha,
+		Blend:          g.blend,
+	}
+	screen.DrawTriangles(g.vertices, g.indices, whiteSubImage, op)
+}
+
+// drawLinks connects every pair of circles closer than linkDistance with a faint
+// line that gets more opaque as the circles approach each other.
+// This is O(n^2), which is cheap for the usual number of circles.
+func (g *Game) drawLinks(screen *ebiten.Image) {
+	for i, a := range g.circles {
+		for _, b := range g.circles[i+1:] {
+			dist := math.Hypot(a.x-b.x, a.y-b.y)
+			if dist >= linkDistance {
+				continue
+			}
+			alpha := uint8(128 * (1 - dist/linkDistance))
+			clr := color.RGBA{alpha, alpha, alpha, alpha} // Premultiplied white
+			vector.StrokeLine(screen, float32(a.x), float32(a.y), float32(b.x), float32(b.y), 1, clr, true)
+		}
 	}
 }
 
 // Layout returns the logical screen size.
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
+	g.width, g.height = outsideWidth, outsideHeight
 	return outsideWidth, outsideHeight
 }
 
-func main() {
-	// Set window properties.
+// setupWindow configures the borderless, click-through overlay window on the
+// given -layer. Ebitengine cannot place a window behind all others, so
+// "background" only disables always-on-top. The layer handling matches
+// setupWindow in misskey-reactions.
+func setupWindow(layer string) {
+	switch layer {
+	case "top":
+		ebiten.SetWindowFloating(true)
+	case "normal":
+		ebiten.SetWindowFloating(false)
+	case "background":
+		log.Println("Layer \"background\" is not supported on this platform; using \"normal\" instead.")
+		ebiten.SetWindowFloating(false)
+	default:
+		log.Fatalf("Invalid -layer %q: must be top, normal or background", layer)
+	}
 	ebiten.SetWindowDecorated(false)
-	ebiten.SetWindowFloating(true)
 	ebiten.SetWindowMousePassthrough(true)
 	ebiten.SetWindowTitle("Floating Circles")
+}
+
+func main() {
+	layer := flag.String("layer", "top", "Window layer: top (always on top), normal or background.")
+	statePath := flag.String("state", "", "Save the circles to this file on exit and restore them from it on start.")
+	maxCircles := flag.Int("max", defaultMaxCircles, "Maximum number of circles on screen.")
+	additive := flag.Bool("additive", false, "Blend overlapping circles additively so they glow. Press B to toggle it while running.")
+	gravityMode := flag.Bool("gravity", false, "Pull circles down so they fall and settle at the bottom.")
+	lines := flag.Bool("lines", false, "Connect nearby circles with lines.")
+	ring := flag.Bool("ring", false, "Draw circles as rings (outline only).")
+	ringWidth := flag.Float64("ring-width", 2, "Stroke width of the -ring outlines.")
+	restitution := flag.Float64("restitution", 1, "Share of the speed kept on each bounce (0.0-1.2). Below 1 slows circles down, above 1 speeds them up.")
+	paletteFlag := flag.String("palette", "", "Comma-separated colors picked at random for new circles, as RRGGBB or RRGGBBAA hex (e.g. ff8080,80c0ff80). Empty draws every circle white.")
+	flag.Parse()
+
+	if *maxCircles < 1 {
+		log.Printf("-max %d must be at least 1, using %d", *maxCircles, defaultMaxCircles)
+		*maxCircles = defaultMaxCircles
+	}
+	if *ringWidth <= 0 {
+		log.Fatalf("Invalid -ring-width %v: must be positive", *ringWidth)
+	}
+	var palette []color.RGBA
+	if *paletteFlag != "" {
+		var err error
+		if palette, err = parsePalette(*paletteFlag); err != nil {
+			log.Fatalf("Invalid -palette %q: %v", *paletteFlag, err)
+		}
+	}
+	if *restitution < 0 || *restitution > maxRestitution {
+		clamped := min(max(*restitution, 0), maxRestitution)
+		log.Printf("-restitution %v is out of range, using %v", *restitution, clamped)
+		*restitution = clamped
+	}
+
+	// Set window properties.
+	setupWindow(*layer)
 
 	// To enable transparency with a fullscreen-like window, we can't use true
 	// fullscreen mode. Instead, we create a window that is almost fullscreen.
@@ -150,6 +613,27 @@ func main() {
 	ebiten.SetWindowSize(screenWidth, screenHeight-1)
 
 	game := NewGame()
+	game.lines = *lines
+	game.maxCircles = *maxCircles
+	if *additive {
+		game.blend = ebiten.BlendLighter
+	}
+	if *statePath != "" {
+		if err := game.loadState(*statePath); err != nil {
+			log.Printf("Failed to restore circles: %v", err)
+		}
+		defer func() {
+			if err := game.saveState(*statePath); err != nil {
+				log.Printf("Failed to save circles: %v", err)
+			}
+		}()
+	}
+	game.gravity = *gravityMode
+	game.restitution = *restitution
+	game.palette = palette
+	if *ring {
+		game.ringWidth = float32(*ringWidth)
+	}
 
 	// As of Ebitengine v2.5, screen transparency is set via RunGameWithOptions.
 	opts := ebiten.RunGameOptions{
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+func TestNewGame(t *testing.T) {
+	g := NewGame()
+	if g == nil {
+		t.Fatal("NewGame returned nil")
+	}
+	if g.rng == nil {
+		t.Error("rng is not set")
+	}
+	if g.maxCircles != defaultMaxCircles {
+		t.Errorf("maxCircles = %d, want %d", g.maxCircles, defaultMaxCircles)
+	}
+	if g.restitution != 1 {
+		t.Errorf("restitution = %v, want 1", g.restitution)
+	}
+	if g.blend != ebiten.BlendSourceOver {
+		t.Error("blend is not BlendSourceOver")
+	}
+}
+
+func TestUpdateSpawnsCircles(t *testing.T) {
+	g := NewGameWithSeed(1)
+	g.Layout(640, 480)
+	for range 600 {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if len(g.circles) > g.maxCircles {
+			t.Fatalf("%d circles, want at most %d", len(g.circles), g.maxCircles)
+		}
+	}
+	if len(g.circles) == 0 {
+		t.Error("no circles spawned in 10 seconds")
+	}
+}
+
+func TestSeedReproducesSpawns(t *testing.T) {
+	a, b := NewGameWithSeed(42), NewGameWithSeed(42)
+	a.spawnCircle(640, 480)
+	b.spawnCircle(640, 480)
+	ca, cb := a.circles[0], b.circles[0]
+	if ca.x != cb.x || ca.y != cb.y || ca.vx != cb.vx || ca.vy != cb.vy || ca.radius != cb.radius {
+		t.Errorf("circles from the same seed differ: %+v and %+v", *ca, *cb)
+	}
+}
+
+func TestBounce(t *testing.T) {
+	tests := []struct {
+		v, restitution, want float64
+	}{
+		{0.1, 1, -0.1},
+		{-0.1, 1, 0.1},
+		{2, 0.5, -1},
+		{0.1, 0.5, -minBounceSpeed},
+		{-0.1, 0.5, minBounceSpeed},
+		{4, 1.2, -maxBounceSpeed},
+	}
+	for _, tt := range tests {
+		if got := bounce(tt.v, tt.restitution); got != tt.want {
+			t.Errorf("bounce(%v, %v) = %v, want %v", tt.v, tt.restitution, got, tt.want)
+		}
+	}
+}
+
+// TestBounceAxis bounces a circle of radius 10 off the walls of a 100 pixel
+// wide window, and one of radius 80 that cannot fit in it.
+func TestBounceAxis(t *testing.T) {
+	tests := []struct {
+		name          string
+		x, vx, radius float64
+		want          float64
+	}{
+		{"inside", 50, 1, 10, 1},
+		{"touching the right wall", 90, 1, 10, 1},
+		{"past the right wall", 91, 1, 10, -1},
+		{"past the right wall heading back in", 91, -1, 10, -1},
+		{"past the left wall", 9, -1, 10, 1},
+		{"at rest past a wall", 95, 0, 10, 0},
+		{"too wide, inside its sweep", 50, 1, 80, 1},
+		{"too wide, past its sweep", 81, 1, 80, -1},
+	}
+	for _, tt := range tests {
+		if got := bounceAxis(tt.x, tt.vx, tt.radius, 0, 100, 1); got != tt.want {
+			t.Errorf("%s: vx = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A circle wider than the window sweeps across it, bouncing once per crossing.
+func TestBounceAxisTooWideCircle(t *testing.T) {
+	c := &Circle{x: 50, vx: 1, radius: 80}
+	bounces := 0
+	for range 200 {
+		c.x += c.vx
+		vx := bounceAxis(c.x, c.vx, c.radius, 0, 100, 1)
+		if vx != c.vx {
+			bounces++
+		}
+		c.vx = vx
+		if c.x < 20-1 || c.x > 80+1 {
+			t.Fatalf("x = %v, want it to stay within [20, 80]", c.x)
+		}
+	}
+	if bounces < 2 || bounces > 200/60+1 {
+		t.Errorf("%d bounces in 200 ticks, want one per 60-pixel crossing", bounces)
+	}
+}
+
+func TestUpdateAfterLayoutShrinks(t *testing.T) {
+	g := NewGameWithSeed(1)
+	g.maxCircles = 0 // Only the circles placed below
+	g.Layout(640, 480)
+	if err := g.Update(); err != nil {
+		t.Fatal(err)
+	}
+	edge := &Circle{x: 590, y: 240, vx: 2, radius: 10, lifetime: maxLifetime}
+	outside := &Circle{x: 620, y: 240, vx: -2, radius: 10, lifetime: maxLifetime}
+	g.circles = []*Circle{edge, outside}
+
+	g.Layout(600, 480)
+	if err := g.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.circles) != 1 || g.circles[0] != edge {
+		t.Fatalf("circles = %v, want only the one straddling the new edge", g.circles)
+	}
+	if edge.vx >= 0 {
+		t.Errorf("circle at the new edge has vx = %v, want it bounced back", edge.vx)
+	}
+	for range 60 {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if edge.x+edge.radius > 600+2 {
+			t.Fatalf("circle escaped the shrunken screen: x = %v", edge.x)
+		}
+	}
+
+	// Growing the screen again moves the wall out without affecting circles.
+	g.Layout(640, 480)
+	if err := g.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.circles) != 1 {
+		t.Errorf("%d circles after growing, want 1", len(g.circles))
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []color.RGBA
+		wantErr bool
+	}{
+		{in: "ff8000", want: []color.RGBA{{0xff, 0x80, 0x00, 0xff}}},
+		{in: "#ffffff, 00ff0080", want: []color.RGBA{{0xff, 0xff, 0xff, 0xff}, {0x00, 0x80, 0x00, 0x80}}},
+		{in: "fff", wantErr: true},
+		{in: "ff8000,", wantErr: true},
+		{in: "gg0000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePalette(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePalette(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parsePalette(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestGravityCirclesSettle(t *testing.T) {
+	g := NewGameWithSeed(1)
+	g.maxCircles = 0 // Only the circle placed below
+	g.gravity = true
+	g.Layout(640, 480)
+	c := &Circle{x: 320, y: 100, radius: 10, lifetime: 2000}
+	g.circles = []*Circle{c}
+	for range 1200 {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.y < 480-c.radius-1 || math.Abs(c.vy) > 2*gravity {
+		t.Errorf("circle at y = %v with vy = %v, want it resting on the bottom wall", c.y, c.vy)
+	}
+}
+
+func TestBurstWhenLifetimeEnds(t *testing.T) {
+	g := NewGameWithSeed(1)
+	g.maxCircles = 0 // Only the circles placed below
+	g.Layout(640, 480)
+	dying := &Circle{x: 320, y: 240, vx: 1, radius: 10, lifetime: 1}
+	gone := &Circle{x: -100, y: 240, vx: -1, radius: 10, lifetime: -10}
+	g.circles = []*Circle{dying, gone}
+	if err := g.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.particles) != particlesPerBurst {
+		t.Errorf("%d particles, want one burst of %d", len(g.particles), particlesPerBurst)
+	}
+	for _, p := range g.particles {
+		if math.Hypot(p.x-dying.x, p.y-dying.y) > 2 { // Particles move up to 2px per tick
+			t.Fatalf("particle at (%v, %v), want it at the dying circle", p.x, p.y)
+		}
+	}
+	if len(g.circles) != 1 || g.circles[0] != dying {
+		t.Errorf("circles = %v, want only the dying one to drift off", g.circles)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+)
+
+// circleState is the saved form of a Circle.
+type circleState struct {
+	X        float64    `json:"x"`
+	Y        float64    `json:"y"`
+	VX       float64    `json:"vx"`
+	VY       float64    `json:"vy"`
+	Radius   float64    `json:"radius"`
+	Lifetime int        `json:"lifetime"`
+	Color    color.RGBA `json:"color"`
+	Shape    Shape      `json:"shape"`
+}
+
+// saveState writes the circles on screen to path, so the next run can continue
+// where this one stopped.
+func (g *Game) saveState(path string) error {
+	state := make([]circleState, 0, len(g.circles))
+	for _, c := range g.circles {
+		state = append(state, circleState{
+			X:        c.x,
+			Y:        c.y,
+			VX:       c.vx,
+			VY:       c.vy,
+			Radius:   c.radius,
+			Lifetime: c.lifetime,
+			Color:    c.col,
+			Shape:    c.shape,
+		})
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadState restores the circles saved by saveState. A missing file is not an
+// error and leaves the screen empty. Circles beyond maxCircles are dropped.
+func (g *Game) loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state []circleState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	for _, s := range state {
+		if len(g.circles) >= g.maxCircles {
+			break
+		}
+		c := &Circle{
+			x:        s.X,
+			y:        s.Y,
+			vx:       s.VX,
+			vy:       s.VY,
+			radius:   s.Radius,
+			lifetime: s.Lifetime,
+			col:      s.Color,
+			shape:    s.Shape,
+		}
+		for i := range c.trail {
+			c.trail[i] = trailPoint{c.x, c.y}
+		}
+		g.circles = append(g.circles, c)
+	}
+	return nil
+}
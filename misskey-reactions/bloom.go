@@ -0,0 +1,59 @@
+package main
+
+import (
+	_ "embed"
+	"image"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// bloomThreshold is the luminance above which drawn pixels start to glow.
+const bloomThreshold = 0.6
+
+//go:embed bloom.kage
+var bloomKage []byte
+
+// bloomShader blurs one axis of an image, optionally keeping only its bright areas.
+var bloomShader *ebiten.Shader
+
+// bloomBuffers returns the offscreen images used by the bloom pass, (re)allocating
+// them when the screen size changes. The first one is cleared for the objects to
+// be drawn onto.
+func (g *Game) bloomBuffers(bounds image.Rectangle) (scene, blurred *ebiten.Image) {
+	if g.bloomScene == nil || g.bloomScene.Bounds() != bounds {
+		if g.bloomScene != nil {
+			g.bloomScene.Deallocate()
+			g.bloomBlurred.Deallocate()
+		}
+		g.bloomScene = ebiten.NewImage(bounds.Dx(), bounds.Dy())
+		g.bloomBlurred = ebiten.NewImage(bounds.Dx(), bounds.Dy())
+	}
+	g.bloomScene.Clear()
+	return g.bloomScene, g.bloomBlurred
+}
+
+// drawBloom draws the scene onto screen, then adds a glow made by blurring the
+// scene's bright areas horizontally and then vertically.
+func (g *Game) drawBloom(screen, scene, blurred *ebiten.Image) {
+	screen.DrawImage(scene, nil)
+
+	w, h := scene.Bounds().Dx(), scene.Bounds().Dy()
+	op := &ebiten.DrawRectShaderOptions{}
+	op.Images[0] = scene
+	op.Uniforms = map[string]any{
+		"Direction": []float32{1, 0},
+		"Threshold": float32(bloomThreshold),
+		"Strength":  float32(1),
+	}
+	blurred.Clear()
+	blurred.DrawRectShader(w, h, bloomShader, op)
+
+	op.Images[0] = blurred
+	op.Uniforms = map[string]any{
+		"Direction": []float32{0, 1},
+		"Threshold": float32(0),
+		"Strength":  float32(g.bloom),
+	}
+	op.Blend = ebiten.BlendLighter
+	screen.DrawRectShader(w, h, bloomShader, op)
+}
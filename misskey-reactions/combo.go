@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+)
+
+// comboLabelSpeed is how fast the "xN combo!" label rises, in pixels per tick.
+const comboLabelSpeed = 1.5
+
+// comboState counts repeats of one reaction that arrived within comboWindow of
+// each other.
+type comboState struct {
+	count int
+	last  time.Time
+}
+
+// countCombo records a spawn of reaction and starts a combo every time the same
+// reaction has arrived comboThreshold more times, each within comboWindow of the
+// one before.
+func (g *Game) countCombo(reaction ReactionInfo, bounds image.Rectangle) {
+	if g.comboThreshold <= 0 || bounds.Empty() {
+		return
+	}
+	if g.combos == nil {
+		g.combos = make(map[string]*comboState)
+	}
+	now := time.Now()
+	for name, s := range g.combos {
+		if now.Sub(s.last) > g.comboWindow {
+			delete(g.combos, name) // The streak is broken
+		}
+	}
+	s := g.combos[reaction.Name]
+	if s == nil {
+		s = &comboState{}
+		g.combos[reaction.Name] = s
+	}
+	s.count++
+	s.last = now
+	if s.count%g.comboThreshold == 0 {
+		g.spawnCombo(reaction, s.count, bounds)
+	}
+}
+
+// spawnCombo shows a rising "xN combo!" label at the center of bounds and bursts
+// comboThreshold copies of the reaction outwards from behind it.
+func (g *Game) spawnCombo(reaction ReactionInfo, count int, bounds image.Rectangle) {
+	cx := float64(bounds.Min.X+bounds.Max.X) / 2
+	cy := float64(bounds.Min.Y+bounds.Max.Y) / 2
+
+	for i := 0; i < g.comboThreshold; i++ {
+		if len(g.objects) >= g.quality.objectLimit() {
+			metrics.reactionsDropped.Inc()
+			break
+		}
+		angle := 2 * math.Pi * float64(i) / float64(g.comboThreshold)
+		obj := g.newObject(reaction.Name, 1, g.newLifetime())
+		obj.x, obj.y = cx, cy
+		obj.vx, obj.vy = math.Cos(angle)*maxObjectSpeed, math.Sin(angle)*maxObjectSpeed
+		g.addObject(obj, reaction)
+	}
+
+	// The label has no image to load. It starts out expired, so it never bounces
+	// and is removed once it has risen out of bounds.
+	label := g.newObject(reaction.Name, 1, 0)
+	label.fallbackText = fmt.Sprintf("x%d combo!", count)
+	label.x, label.y = cx, cy
+	label.vy = -comboLabelSpeed
+	label.minVisibleTicks = 0
+	label.wobbleAmp = 0
+	label.appearTicks, label.appearFade = appearDuration, false
+	g.objects = append(g.objects, label)
+}
@@ -3,27 +3,79 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 // Config holds the application configuration.
 type Config struct {
 	MisskeyInstance string `json:"misskey_instance"`
 	AccessToken     string `json:"access_token"`
+
+	// GroupNoteReactions spawns all reactions on a note as a row instead of
+	// just the one that was added.
+	GroupNoteReactions bool `json:"group_note_reactions"`
+
+	// TrackReactionRemoval watches reacted notes and pops matching objects off
+	// the screen when a reaction is removed.
+	TrackReactionRemoval bool `json:"track_reaction_removal"`
+
+	// FallbackImage is the path of an image shown for reactions whose emoji
+	// cannot be fetched or decoded, instead of their shortcode.
+	FallbackImage string `json:"fallback_image"`
+
+	// MediaProxy is a URL template such as "https://myproxy/?url={url}" that
+	// image URLs are rewritten to, with {url} replaced by the escaped original.
+	MediaProxy string `json:"media_proxy"`
+
+	// Effects maps reaction names such as ":angry:" or "👍" to an effect applied
+	// to their objects. The only effect so far is "shake".
+	Effects map[string]string `json:"effects"`
+
+	// StaticFormats lists animated formats ("gif", "apng" or "webp") that are
+	// decoded as still images, to rule a decoder out when tracking down problems.
+	StaticFormats []string `json:"static_formats"`
+
+	// WebhookToken is the bearer token required by the webhook reaction source.
+	// Requests are not checked when it is empty.
+	WebhookToken string `json:"webhook_token"`
 }
 
-// loadConfig reads and parses the config.json file.
-func loadConfig() (*Config, error) {
-	data, err := os.ReadFile("config.json")
+// loadConfig reads and parses the config file at path. A path of "-" reads the
+// config from stdin so secrets don't have to be written to disk. The Misskey
+// instance and token may only be left out when requireMisskey is false.
+func loadConfig(path string, requireMisskey bool) (*Config, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		path = "stdin"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("cannot read config.json: %w", err)
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
 	}
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("invalid format in config.json: %w", err)
+		return nil, fmt.Errorf("invalid format in %s: %w", path, err)
+	}
+	if requireMisskey && (cfg.MisskeyInstance == "" || cfg.MisskeyInstance == "your.misskey.instance.com" || cfg.AccessToken == "" || cfg.AccessToken == "YOUR_MISSKEY_ACCESS_TOKEN") {
+		return nil, fmt.Errorf("please update %s", path)
+	}
+	if cfg.MediaProxy != "" && !strings.Contains(cfg.MediaProxy, "{url}") {
+		return nil, fmt.Errorf("media_proxy in %s must contain {url}", path)
+	}
+	for name, effect := range cfg.Effects {
+		if _, ok := objectEffects[effect]; !ok {
+			return nil, fmt.Errorf("unknown effect %q for %s in %s: must be shake", effect, name, path)
+		}
 	}
-	if cfg.MisskeyInstance == "" || cfg.MisskeyInstance == "your.misskey.instance.com" || cfg.AccessToken == "" || cfg.AccessToken == "YOUR_MISSKEY_ACCESS_TOKEN" {
-		return nil, fmt.Errorf("please update config.json")
+	for _, format := range cfg.StaticFormats {
+		if format != "gif" && format != "apng" && format != "webp" {
+			return nil, fmt.Errorf("unknown format %q in static_formats in %s: must be gif, apng or webp", format, path)
+		}
 	}
 	return &cfg, nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// redacted replaces secrets in the diagnostics bundle.
+const redacted = "REDACTED"
+
+// diagnostics is the bug report bundle written by -diag.
+type diagnostics struct {
+	Version     string            `json:"version"`
+	Revision    string            `json:"revision"`
+	GoVersion   string            `json:"go_version"`
+	OS          string            `json:"os"`
+	Arch        string            `json:"arch"`
+	Flags       map[string]string `json:"flags"`
+	Config      *Config           `json:"config,omitempty"`
+	ConfigError string            `json:"config_error,omitempty"`
+	Monitors    []monitorInfo     `json:"monitors"`
+	Cache       *cacheStats       `json:"cache,omitempty"`
+}
+
+type monitorInfo struct {
+	Name        string  `json:"name"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	ScaleFactor float64 `json:"scale_factor"`
+}
+
+// cacheStats is only meaningful once the overlay has been running for a while,
+// so it is left out of the bundle written by -diag at startup.
+type cacheStats struct {
+	Entries      int64  `json:"entries"`
+	FetchSuccess uint64 `json:"fetch_success"`
+	FetchFailure uint64 `json:"fetch_failure"`
+}
+
+// writeDiagnostics writes version and platform information, the flag values,
+// the config at configPath with its secrets redacted and the monitors to a
+// timestamped JSON file, so users can attach it to bug reports. The cache
+// statistics are included if withCache is set.
+func writeDiagnostics(configPath string, withCache bool) error {
+	d := diagnostics{
+		Version:   version,
+		Revision:  revision,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Flags:     make(map[string]string),
+	}
+	if withCache {
+		d.Cache = &cacheStats{
+			Entries:      metrics.cacheSize.Value(),
+			FetchSuccess: metrics.fetchSuccess.Value(),
+			FetchFailure: metrics.fetchFailure.Value(),
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		d.Flags[f.Name] = f.Value.String()
+	})
+	if cfg, err := loadConfig(configPath, false); err != nil {
+		d.ConfigError = err.Error()
+	} else {
+		if cfg.AccessToken != "" {
+			cfg.AccessToken = redacted
+		}
+		if cfg.WebhookToken != "" {
+			cfg.WebhookToken = redacted
+		}
+		d.Config = cfg
+	}
+	for _, m := range ebiten.AppendMonitors(nil) {
+		w, h := m.Size()
+		d.Monitors = append(d.Monitors, monitorInfo{Name: m.Name(), Width: w, Height: h, ScaleFactor: m.DeviceScaleFactor()})
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("diag-%s.json", time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("Saved diagnostics to %s", path)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// diskCache keeps the fetched bytes of images in a directory, so images don't
+// have to be downloaded again after a restart. The bytes are stored as served,
+// as PNG, GIF, WebP and so on, and decoded again when loaded.
+type diskCache struct {
+	dir string
+}
+
+// defaultDiskCacheDir returns the directory used by -disk-cache, inside the
+// user's cache directory.
+func defaultDiskCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "misskey-reactions", "images"), nil
+}
+
+// path returns the file the image fetched from url is stored in.
+func (c *diskCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// load returns the stored bytes of the image fetched from url, if any.
+func (c *diskCache) load(url string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read cached image for %s: %v", url, err)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+// store saves the bytes of the image fetched from url. They are written to a
+// temporary file first, so a crash never leaves a truncated image behind.
+func (c *diskCache) store(url string, data []byte) {
+	if err := c.write(c.path(url), data); err != nil {
+		log.Printf("Failed to cache image for %s: %v", url, err)
+	}
+}
+
+func (c *diskCache) write(path string, data []byte) error {
+	f, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot move into place: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the stored image fetched from url, for when it can't be decoded.
+func (c *diskCache) remove(url string) {
+	if err := os.Remove(c.path(url)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove cached image for %s: %v", url, err)
+	}
+}
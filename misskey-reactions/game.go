@@ -1,27 +1,61 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"image"
 	"image/color"
+	"log"
 	"math"
 	"math/rand"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text/v2"
 )
 
 const (
-	maxObjects             = 100
-	minLifetime            = 300
-	maxLifetime            = 900
-	objectHalfSize         = 36.0 // Assumes 72x72 images, used for padding
-	minObjectSpeed         = 0.5
-	maxObjectSpeed         = 2.0
-	objectAngleSpread      = math.Pi / 2
-	defaultFrameDelayTicks = 6
+	minAnimSpeed      = 0.1
+	maxAnimSpeed      = 10.0
+	maxObjects        = 100
+	minLifetime       = 300
+	maxLifetime       = 900
+	objectHalfSize    = 36.0 // Assumes 72x72 images, used for padding and as the box of imageFit
+	minObjectSpeed    = 0.5
+	maxObjectSpeed    = 2.0
+	objectAngleSpread = math.Pi / 2
+	maxRestitution    = 1.2
+	maxBounceSpeed    = 4.0                    // Cap for objects sped up by a restitution above 1
+	minBounceSpeed    = 0.25                   // Floor for objects slowed down by a restitution below 1
+	rainGravity       = 0.02                   // Downward acceleration of falling objects, per tick
+	rainDrift         = 0.6                    // Range of the horizontal speed of falling objects
+	wobbleFrequency   = 0.05                   // Radians per tick of the wobble, about a 2 second period
+	defaultFrameDelay = 100 * time.Millisecond // Used for frames that declare a delay of 0
+	popDuration       = 12                     // Ticks for a removed reaction to pop off the screen
+	appearDuration    = 12                     // Ticks of the entrance animation
+	weightWindow      = 30 * time.Second       // How long a reaction counts towards its weight
+	weightStep        = 0.1                    // Scale added for every recent repeat of a reaction
+	trailLength       = 6                      // Number of past positions drawn as an afterimage
+	shakeDistance     = 2.0                    // Largest offset in pixels of the shake effect
+	shakeAngle        = 0.08                   // Largest rotation in radians of the shake effect
+	maxSpin           = 0.02                   // Largest rotation speed in radians per tick
+	fadeInTicks       = 20                     // Ticks of the lifetime fade-in after spawning
+	fadeOutTicks      = 60                     // Ticks of the lifetime fade-out before the object expires
 )
 
 var (
 	fallbackFont *text.GoTextFace
+	labelFont    *text.GoTextFace
+
+	// deviceScaleFactor reports the scale factor of the current monitor.
+	// It is a variable so the coordinate math can be exercised without a display.
+	deviceScaleFactor = func() float64 {
+		return ebiten.Monitor().DeviceScaleFactor()
+	}
 )
 
 // ReactionObject represents a single floating reaction on the screen.
@@ -29,156 +63,863 @@ type ReactionObject struct {
 	x, y, vx, vy         float64
 	lifetime             int
 	reactionName         string
+	reactionURL          string // Custom emoji URL from the reaction, if any
 	image                *ebiten.Image
 	animatedImage        *AnimatedImage
 	currentFrame         int
 	frameTimeAccumulator float64
+	loopsPlayed          int
+	animationDone        bool
+	playOnce             bool          // Stop after the first loop regardless of the image's loop count
+	holdFirstFrame       bool          // Show the first frame instead of the last once stopped
+	animSpeed            float64       // Playback speed multiplier, 1 is the animation's own speed
+	zeroDelay            time.Duration // Delay used for frames that declare a delay of 0
 	fallbackText         string
 	scale                float64
+	flipped              bool
+	showLabel            bool
+	trailEnabled         bool
+	trail                [trailLength]trailPoint // Ring buffer of recent positions
+	trailHead, trailLen  int
+
+	// Reactions on the same note drift together as a row. Members follow the
+	// leader at a fixed horizontal offset; the leader bounces for the whole row.
+	leader         *ReactionObject
+	groupOffset    float64
+	groupHalfWidth float64
+	removed        bool
+
+	popTicks    int  // Remaining ticks of the pop animation, 0 when not popping
+	appearTicks int  // Remaining ticks of the entrance animation, 0 when fully shown
+	appearFade  bool // Fade in during the entrance instead of growing
+
+	visibleTicks    int // Ticks spent at least partly inside the window
+	minVisibleTicks int // Ticks the object must be visible before it may leave
+
+	restitution float64            // Share of the speed kept on each bounce
+	cancelLoad  context.CancelFunc // Aborts loading the image once the object is removed
+	falling     bool               // Falls through the bounds instead of bouncing, see Game.rain
+
+	wobblePhase float64 // Current phase of the sideways wobble in radians
+	wobbleAmp   float64 // Sideways wobble distance in pixels, 0 disables it
+
+	fit imageFit // How the image is mapped into the objectHalfSize box
+
+	spawnLifetime int // Lifetime at spawn for the lifetime fade, 0 disables the fade
+
+	angle float64 // Rotation of the image in radians
+	spin  float64 // Change of angle per tick
+
+	effect                   objectEffect
+	shakeX, shakeY, shakeRot float64 // Current jitter of effectShake, only applied when drawing
+}
+
+// trailPoint is a past position of a ReactionObject.
+type trailPoint struct {
+	x, y float64
 }
 
 // Update proceeds the object's state and returns true if it should be kept alive.
-func (o *ReactionObject) Update(windowWidth, windowHeight int) bool {
-	o.x += o.vx
-	o.y += o.vy
+// The object bounces off the edges of bounds and is removed once it leaves it.
+func (o *ReactionObject) Update(bounds image.Rectangle) bool {
+	if o.trailEnabled {
+		o.trail[o.trailHead] = trailPoint{o.x, o.y}
+		o.trailHead = (o.trailHead + 1) % trailLength
+		o.trailLen = min(o.trailLen+1, trailLength)
+	}
+	if o.leader != nil {
+		o.x, o.y = o.leader.x+o.groupOffset, o.leader.y
+	} else {
+		if o.falling {
+			o.vy += rainGravity
+		}
+		o.x += o.vx
+		o.y += o.vy
+		o.wobble()
+	}
 	o.lifetime--
+	o.angle += o.spin
+	if o.effect == effectShake {
+		o.shakeX = (rand.Float64()*2 - 1) * shakeDistance
+		o.shakeY = (rand.Float64()*2 - 1) * shakeDistance
+		o.shakeRot = (rand.Float64()*2 - 1) * shakeAngle
+	}
 
-	if o.animatedImage != nil && len(o.animatedImage.Frames) > 0 {
-		o.frameTimeAccumulator += 1000.0 / 60.0 // Ebiten runs at 60 TPS
+	if o.appearTicks > 0 {
+		o.appearTicks--
+	}
+	if o.popTicks > 0 {
+		o.popTicks--
+		if o.popTicks == 0 {
+			return false // Pop animation finished
+		}
+	}
+
+	if o.animatedImage != nil && len(o.animatedImage.Frames) > 0 && !o.animationDone {
+		o.currentFrame %= len(o.animatedImage.Frames)         // A restored snapshot may name a frame the image lacks
+		o.frameTimeAccumulator += 1000.0 / 60.0 * o.animSpeed // Ebiten runs at 60 TPS
 
 		delayMs := float64(o.animatedImage.FrameDelays[o.currentFrame])
 		if delayMs == 0 {
 			// Use a default delay if the animation doesn't specify one.
-			// defaultFrameDelayTicks is 6, which is 100ms.
-			delayMs = 100.0
+			delayMs = float64(o.zeroDelay.Milliseconds())
 		}
 
 		if o.frameTimeAccumulator >= delayMs {
 			o.frameTimeAccumulator -= delayMs
-			o.currentFrame = (o.currentFrame + 1) % len(o.animatedImage.Frames)
+			o.advanceFrame()
 		}
 	}
 
+	if o.leader != nil {
+		return !o.leader.removed // Group members live and die with their leader
+	}
+
 	padding := objectHalfSize * o.scale
-	isOutside := o.x+padding < 0 || o.x-padding > float64(windowWidth) || o.y+padding < 0 || o.y-padding > float64(windowHeight)
-	if o.lifetime < 0 && isOutside {
+	paddingX := padding + o.groupHalfWidth
+	minX, minY := float64(bounds.Min.X), float64(bounds.Min.Y)
+	maxX, maxY := float64(bounds.Max.X), float64(bounds.Max.Y)
+	isOutside := o.x+paddingX < minX || o.x-paddingX > maxX || o.y+padding < minY || o.y-padding > maxY
+	if !isOutside {
+		o.visibleTicks++
+	}
+	if o.falling {
+		return o.y-padding <= maxY // Removed once it has fallen past the bottom edge
+	}
+	// Keep the object bouncing until it has been visible for its minimum time,
+	// even if its lifetime has already run out.
+	expired := o.lifetime < 0 && o.visibleTicks >= o.minVisibleTicks
+	if expired && isOutside {
 		return false // Should be removed
 	}
-	if o.lifetime >= 0 {
-		if (o.vx < 0 && o.x-padding < 0) || (o.vx > 0 && o.x+padding > float64(windowWidth)) {
-			o.vx *= -1
-		}
-		if (o.vy < 0 && o.y-padding < 0) || (o.vy > 0 && o.y+padding > float64(windowHeight)) {
-			o.vy *= -1
-		}
+	if !expired {
+		o.vx = bounceAxis(o.x, o.vx, paddingX, minX, maxX, o.restitution)
+		o.vy = bounceAxis(o.y, o.vy, padding, minY, maxY, o.restitution)
 	}
 	return true // Keep alive
 }
 
-// Draw renders the object on the screen.
-func (o *ReactionObject) Draw(screen *ebiten.Image) {
+// wobble moves the object sideways to its velocity so that it drifts along a
+// sine wave of wobbleAmp pixels instead of a straight line.
+func (o *ReactionObject) wobble() {
+	speed := math.Hypot(o.vx, o.vy)
+	if o.wobbleAmp == 0 || speed == 0 {
+		return
+	}
+	// Step by the derivative of wobbleAmp*sin(phase) along the perpendicular.
+	d := o.wobbleAmp * math.Cos(o.wobblePhase) * wobbleFrequency
+	o.x += -o.vy / speed * d
+	o.y += o.vx / speed * d
+	o.wobblePhase += wobbleFrequency
+}
+
+// bounce reverses the velocity component v, scaled by restitution. Speeds gained
+// from a restitution above 1 are capped at maxBounceSpeed, and speeds lost to a
+// restitution below 1 stop at minBounceSpeed, so an object never comes to rest
+// inside the bounds, where it could never leave once its lifetime is over. A
+// restitution of 1 only reverses v.
+func bounce(v, restitution float64) float64 {
+	v *= -restitution
+	if restitution != 1 && math.Abs(v) < minBounceSpeed {
+		v = math.Copysign(minBounceSpeed, v)
+	}
+	return max(min(v, maxBounceSpeed), -maxBounceSpeed)
+}
+
+// bounceAxis returns the velocity v along one axis of an object at pos that
+// extends padding to both sides, reversed if it is heading out of [lo, hi].
+// An object larger than the range sweeps between its two walls instead of
+// touching both at once, which would reverse it every tick.
+func bounceAxis(pos, v, padding, lo, hi, restitution float64) float64 {
+	minPos, maxPos := lo+padding, hi-padding
+	if minPos > maxPos {
+		minPos, maxPos = maxPos, minPos
+	}
+	if (v < 0 && pos < minPos) || (v > 0 && pos > maxPos) {
+		return bounce(v, restitution)
+	}
+	return v
+}
+
+// advanceFrame moves to the next animation frame, stopping on the hold frame
+// once the animation has played its number of loops.
+func (o *ReactionObject) advanceFrame() {
+	o.currentFrame++
+	if o.currentFrame < len(o.animatedImage.Frames) {
+		return
+	}
+	o.currentFrame = 0
+	o.loopsPlayed++
+
+	loops := o.animatedImage.Loops
+	if o.playOnce {
+		loops = 1
+	}
+	if loops > 0 && o.loopsPlayed >= loops {
+		o.animationDone = true
+		if !o.holdFirstFrame {
+			o.currentFrame = len(o.animatedImage.Frames) - 1
+		}
+	}
+}
+
+// popScale returns the scale multiplier of the pop and appear animations: a
+// popping object briefly swells and then shrinks to nothing, and an appearing
+// object grows from nothing with a slight overshoot.
+func (o *ReactionObject) popScale() float64 {
+	s := 1.0
+	if o.appearTicks > 0 && !o.appearFade {
+		t := 1 - float64(o.appearTicks)/appearDuration
+		s = t * (1 + 0.5*(1-t))
+	}
+	if o.popTicks > 0 {
+		t := 1 - float64(o.popTicks)/popDuration
+		s *= (1 - t) * (1 + 2*t)
+	}
+	return s
+}
+
+// appearAlpha returns the opacity of the fade-in entrance animation.
+func (o *ReactionObject) appearAlpha() float32 {
+	if o.appearTicks <= 0 || !o.appearFade {
+		return 1
+	}
+	return 1 - float32(o.appearTicks)/appearDuration
+}
+
+// lifetimeAlpha returns the opacity of the lifetime fade, rising over the first
+// fadeInTicks after spawning and falling to 0 over the last fadeOutTicks before
+// the object expires. Objects without a spawnLifetime are always opaque.
+func (o *ReactionObject) lifetimeAlpha() float32 {
+	if o.spawnLifetime <= 0 {
+		return 1
+	}
+	age := o.spawnLifetime - o.lifetime
+	remaining := max(o.lifetime, o.minVisibleTicks-o.visibleTicks)
+	a := min(float32(age)/fadeInTicks, float32(remaining)/fadeOutTicks, 1)
+	return max(a, 0)
+}
+
+// alpha returns the opacity the object is drawn with.
+func (o *ReactionObject) alpha() float32 {
+	return o.appearAlpha() * o.lifetimeAlpha()
+}
+
+// imageGeoM returns the transform that draws a w x h image centered on the
+// object's position, rotated around its center by the object's angle and scaled
+// by the object scale and the device scale factor.
+func (o *ReactionObject) imageGeoM(w, h int, deviceScale float64) ebiten.GeoM {
+	var m ebiten.GeoM
+	m.Translate(-float64(w)/2, -float64(h)/2)
+	if o.flipped {
+		// Mirror around the image center, which is the origin at this point.
+		m.Scale(-1, 1)
+	}
+	m.Rotate(o.angle)
+	s := o.scale * o.popScale()
+	m.Scale(s, s)
+	m.Scale(deviceScale, deviceScale)
+	m.Rotate(o.shakeRot)
+	m.Translate(o.x+o.shakeX, o.y+o.shakeY)
+	return m
+}
+
+// fitImage applies the object's imageFit to img. It returns the part of img to
+// draw and the scale that maps it into the box of 2*objectHalfSize pixels, which
+// is also the extent the bounce checks assume.
+func (o *ReactionObject) fitImage(img *ebiten.Image) (*ebiten.Image, float64) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	box := 2 * objectHalfSize
+	switch o.fit {
+	case fitContain:
+		return img, box / float64(max(w, h))
+	case fitCover:
+		// Crop the longer side to a centered square, which then fills the box.
+		side := min(w, h)
+		x, y := b.Min.X+(w-side)/2, b.Min.Y+(h-side)/2
+		return img.SubImage(image.Rect(x, y, x+side, y+side)).(*ebiten.Image), box / float64(side)
+	default:
+		return img, 1
+	}
+}
+
+// drawOptions holds option values that are reused for every object and frame,
+// so drawing doesn't allocate per object, and the effects currently enabled.
+type drawOptions struct {
+	image ebiten.DrawImageOptions
+	text  text.DrawOptions
+
+	noTrails bool
+	noLabels bool
+}
+
+// Draw renders the object on the screen using the shared options in ops.
+func (o *ReactionObject) Draw(screen *ebiten.Image, ops *drawOptions) {
 	var imgToDraw *ebiten.Image
 	if o.animatedImage != nil && len(o.animatedImage.Frames) > 0 {
-		imgToDraw = o.animatedImage.Frames[o.currentFrame]
+		imgToDraw = o.animatedImage.Frames[o.currentFrame%len(o.animatedImage.Frames)]
 	} else if o.image != nil {
 		imgToDraw = o.image
 	}
 
 	if imgToDraw != nil {
-		op := &ebiten.DrawImageOptions{}
+		op := &ops.image
+		imgToDraw, fitScale := o.fitImage(imgToDraw)
 		w, h := imgToDraw.Bounds().Dx(), imgToDraw.Bounds().Dy()
-		op.GeoM.Translate(-float64(w)/2, -float64(h)/2)
-		op.GeoM.Scale(o.scale, o.scale)
-		scale := ebiten.Monitor().DeviceScaleFactor()
-		op.GeoM.Scale(scale, scale)
-		op.GeoM.Translate(o.x, o.y)
+		geoM := o.imageGeoM(w, h, fitScale*deviceScaleFactor())
 		op.Filter = ebiten.FilterLinear
+
+		// Draw the afterimage from oldest to newest, fading in towards the object.
+		for i := 0; i < o.trailLen && !ops.noTrails; i++ {
+			p := o.trail[(o.trailHead-o.trailLen+i+trailLength)%trailLength]
+			op.GeoM = geoM
+			op.GeoM.Translate(p.x-o.x, p.y-o.y)
+			op.ColorScale.Reset()
+			op.ColorScale.ScaleAlpha(0.5 * float32(i+1) / float32(trailLength+1) * o.lifetimeAlpha())
+			screen.DrawImage(imgToDraw, op)
+		}
+
+		op.GeoM = geoM
+		op.ColorScale.Reset()
+		op.ColorScale.ScaleAlpha(o.alpha())
 		screen.DrawImage(imgToDraw, op)
+
+		if o.showLabel && !ops.noLabels {
+			o.drawLabel(screen, &ops.text, o.y+float64(h)/2*fitScale*o.scale*deviceScaleFactor())
+		}
 	} else if o.fallbackText != "" {
-		op := &text.DrawOptions{}
+		op := &ops.text
 		width, height := text.Measure(o.fallbackText, fallbackFont, fallbackFont.Size)
-		x := o.x - width/2
-		y := o.y - height/2
-		op.GeoM.Translate(x, y)
-		op.ColorScale.ScaleWithColor(color.White)
+		op.GeoM.Reset()
+		op.GeoM.Translate(-width/2, -height/2)
+		op.GeoM.Scale(o.popScale(), o.popScale())
+		op.GeoM.Rotate(o.shakeRot)
+		op.GeoM.Translate(o.x+o.shakeX, o.y+o.shakeY)
+		op.ColorScale.Reset()
+		op.ColorScale.ScaleWithColor(colorForName(o.reactionName))
+		op.ColorScale.ScaleAlpha(o.alpha())
 		text.Draw(screen, o.fallbackText, fallbackFont, op)
+
+		if o.showLabel && !ops.noLabels {
+			o.drawLabel(screen, op, o.y+height/2)
+		}
+	}
+}
+
+// drawLabel draws the reaction's shortcode centered horizontally with its top at y.
+// A dark shadow is drawn first so the label stays readable on any background.
+func (o *ReactionObject) drawLabel(screen *ebiten.Image, op *text.DrawOptions, y float64) {
+	label := strings.Trim(o.reactionName, ":")
+	width, _ := text.Measure(label, labelFont, labelFont.Size)
+	x := o.x - width/2
+
+	op.GeoM.Reset()
+	op.GeoM.Translate(x+1, y+1)
+	op.ColorScale.Reset()
+	op.ColorScale.ScaleWithColor(color.Black)
+	op.ColorScale.ScaleAlpha(o.alpha())
+	text.Draw(screen, label, labelFont, op)
+
+	op.GeoM.Reset()
+	op.GeoM.Translate(x, y)
+	op.ColorScale.Reset()
+	op.ColorScale.ScaleWithColor(colorForName(o.reactionName))
+	op.ColorScale.ScaleAlpha(o.alpha())
+	text.Draw(screen, label, labelFont, op)
+}
+
+// colorForName returns a bright accent color derived from a hash of name, so the
+// same reaction always gets the same color across runs.
+func colorForName(name string) color.Color {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hue := float64(h.Sum32()%360) / 60
+
+	// HSV to RGB with a fixed saturation of 0.5 and a value of 1.
+	const v, s = 1.0, 0.5
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(hue, 2)-1))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
 	}
+	m := v - c
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
 }
 
+// spawnDirection selects where new objects appear and which way they head.
+type spawnDirection int
+
+const (
+	directionInward  spawnDirection = iota // From a random edge towards the center
+	directionOutward                       // From the center towards the edges
+	directionRandom                        // From a random edge in any direction
+)
+
+// entranceAnimation selects how newly spawned objects appear.
+type entranceAnimation int
+
+const (
+	entranceNone entranceAnimation = iota
+	entrancePop                    // Grow from nothing with a slight overshoot
+	entranceFade                   // Fade in from transparent
+)
+
+// imageFit selects how images are mapped into the box of an object.
+type imageFit int
+
+const (
+	fitNone    imageFit = iota // Draw at the image's own size
+	fitContain                 // Scale to fit inside the box, letterboxing non-square images
+	fitCover                   // Scale to fill the box, cropping the longer side
+)
+
+// objectEffect is an extra motion given to the objects of configured reactions.
+type objectEffect int
+
+const (
+	effectNone  objectEffect = iota
+	effectShake              // Jitter in place, drawn only so it doesn't affect bouncing
+)
+
+// objectEffects maps the effect names used in the config to effects.
+var objectEffects = map[string]objectEffect{"shake": effectShake}
+
 // Game holds the main game state and dependencies.
 type Game struct {
 	objects      []*ReactionObject
 	reactionChan <-chan ReactionInfo
 	imageManager *ImageManager
+	randomFlip   bool            // Mirror roughly half of the spawned objects horizontally
+	playOnce     bool            // Freeze animations after their first loop
+	holdFirst    bool            // Hold the first frame instead of the last when frozen
+	trail        bool            // Draw a fading afterimage behind moving objects
+	lifetime     int             // Fixed lifetime in ticks for every object, 0 means random
+	labels       bool            // Draw the shortcode under every object
+	dim          float64         // Opacity of the black backdrop drawn behind the objects
+	maxAge       time.Duration   // Reactions queued for longer than this are dropped, 0 keeps all
+	animSpeed    float64         // Animation playback speed multiplier
+	zeroDelay    time.Duration   // Frame delay used for frames that declare a delay of 0
+	region       image.Rectangle // Area to spawn, bounce and draw objects in, empty means the whole window
+	direction    spawnDirection
+	maxWeight    float64 // Largest scale multiplier for repeated reactions, 1 or less disables weighting
+	restitution  float64 // Share of the speed objects keep on each bounce
+	opaqueClear  bool    // Clear to opaque black instead of transparent, for setups that flicker
+	grid         bool    // Place objects in a grid of cells instead of floating them
+	rain         bool    // Drop objects from the top edge instead of bouncing them around
+	entrance     entranceAnimation
+	fit          imageFit
+	oldestOnTop  bool    // Draw newer objects below older ones
+	lifetimeFade bool    // Fade objects in after spawning and out before they expire
+	wobble       float64 // Largest sideways wobble distance in pixels, 0 disables it
+	bloom        float64 // Strength of the glow around bright areas, 0 disables it
+	counter      bool    // Draw the uptime and number of received reactions in a corner
+	burstKey     ebiten.Key
+	configPath   string // Config file described by the diagnostics F10 writes
+
+	maxSpawnsPerTick int // Most reactions taken from reactionChan in one tick
+	burstSize        int // Number of recent unique reactions burstKey spawns again, 0 disables it
+
+	history []ReactionInfo // Most recent unique reactions, newest last
+
+	comboThreshold int                    // Repeats of a reaction that make a combo, 0 disables combos
+	comboWindow    time.Duration          // Longest gap between repeats that keeps a combo going
+	combos         map[string]*comboState // Current streaks by reaction name
+
+	effects map[string]objectEffect // Effects by reaction name, from the config
+
+	startedAt time.Time
+
+	gridCells map[int]*ReactionObject // Objects by grid cell index
+	gridNext  int                     // Index of the next grid cell to fill
+
+	bloomScene, bloomBlurred *ebiten.Image // Offscreen images for the bloom pass
+
+	recentReactions map[string][]time.Time // Spawn times within weightWindow, by reaction name
+
+	minVisibleTicks int // Minimum ticks every object stays visible before it can leave
+
+	screenWidth, screenHeight int // Size of the screen from the last Layout in device pixels, 0 while minimized
+
+	quality qualityController
+
+	drawOps drawOptions
 }
 
-// NewGame creates a new game instance with its dependencies.
+// Statically check that *Game implements ebiten.Game.
+var _ ebiten.Game = (*Game)(nil)
+
+// NewGame creates a new game instance with its dependencies. Reactions arrive on rc;
+// the Misskey client is reached through im rather than being injected separately.
 func NewGame(rc <-chan ReactionInfo, im *ImageManager) *Game {
 	return &Game{
-		reactionChan: rc,
-		imageManager: im,
+		reactionChan:     rc,
+		imageManager:     im,
+		animSpeed:        1,
+		zeroDelay:        defaultFrameDelay,
+		startedAt:        time.Now(),
+		restitution:      1,
+		maxSpawnsPerTick: 1,
 	}
 }
 
-func (g *Game) spawnReaction(reaction ReactionInfo, w, h int) {
-	if len(g.objects) >= maxObjects {
+// spawnReaction creates an object according to the game's spawn direction: just
+// outside a random edge of bounds, or at its center for directionOutward. In grid
+// layout, the reaction and its group take the next grid cells instead.
+func (g *Game) spawnReaction(reaction ReactionInfo, bounds image.Rectangle) {
+	if bounds.Empty() {
+		// The window is minimized or not laid out yet, so there is nowhere to spawn.
+		metrics.reactionsDropped.Inc()
+		return
+	}
+	if g.grid {
+		g.spawnGridReaction(reaction, bounds)
+		for _, member := range reaction.Group {
+			g.spawnGridReaction(member, bounds)
+		}
 		return
 	}
+	if len(g.objects) >= g.quality.objectLimit() {
+		metrics.reactionsDropped.Inc()
+		return
+	}
+	minX, minY := float64(bounds.Min.X), float64(bounds.Min.Y)
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
 	var x, y float64
 	edge := rand.Intn(4)
-	scale := 0.5 + rand.Float64() // Random scale from 0.5 to 1.5
+	scale := (0.5 + rand.Float64()) * g.weight(reaction.Name) // Random scale from 0.5 to 1.5, grown for repeats
 	padding := objectHalfSize * scale
 	switch edge {
 	case 0:
-		x, y = rand.Float64()*float64(w), -padding
+		x, y = minX+rand.Float64()*w, minY-padding
 	case 1:
-		x, y = float64(w)+padding, rand.Float64()*float64(h)
+		x, y = minX+w+padding, minY+rand.Float64()*h
 	case 2:
-		x, y = rand.Float64()*float64(w), float64(h)+padding
+		x, y = minX+rand.Float64()*w, minY+h+padding
 	case 3:
-		x, y = -padding, rand.Float64()*float64(h)
+		x, y = minX-padding, minY+rand.Float64()*h
+	}
+	var angle float64
+	switch g.direction {
+	case directionOutward:
+		x, y = minX+w/2, minY+h/2
+		angle = rand.Float64() * 2 * math.Pi
+	case directionRandom:
+		// Objects heading away from the bounds are turned back by the bounce check.
+		angle = rand.Float64() * 2 * math.Pi
+	default:
+		angle = math.Atan2(minY+h/2-y, minX+w/2-x) + (rand.Float64()-0.5)*objectAngleSpread
 	}
-	angle := math.Atan2(float64(h/2)-y, float64(w/2)-x) + (rand.Float64()-0.5)*objectAngleSpread
 	speed := minObjectSpeed + rand.Float64()*(maxObjectSpeed-minObjectSpeed)
+	lifetime := g.newLifetime()
+	if reaction.LifetimeTicks > 0 {
+		lifetime = reaction.LifetimeTicks
+	}
+	obj := g.newObject(reaction.Name, scale, lifetime)
+	obj.x, obj.y = x, y
+	obj.vx, obj.vy = math.Cos(angle)*speed, math.Sin(angle)*speed
+	obj.spin = (rand.Float64()*2 - 1) * maxSpin
+	if g.rain {
+		obj.x, obj.y = minX+rand.Float64()*w, minY-padding
+		obj.vx, obj.vy = (rand.Float64()-0.5)*rainDrift, speed
+		obj.falling = true
+	}
+	g.addObject(obj, reaction)
+
+	g.spawnGroupMembers(obj, reaction.Group)
+}
+
+// weight records a spawn of the named reaction and returns its scale multiplier:
+// reactions repeated within weightWindow grow by weightStep each, up to maxWeight.
+func (g *Game) weight(name string) float64 {
+	if g.maxWeight <= 1 {
+		return 1
+	}
+	if g.recentReactions == nil {
+		g.recentReactions = make(map[string][]time.Time)
+	}
+	now := time.Now()
+	times := g.recentReactions[name]
+	for len(times) > 0 && now.Sub(times[0]) > weightWindow {
+		times = times[1:]
+	}
+	times = append(times, now)
+	g.recentReactions[name] = times
+	return min(1+float64(len(times)-1)*weightStep, g.maxWeight)
+}
+
+// newObject creates an object for the named reaction with the display options
+// configured on the game. The caller sets its position and motion.
+func (g *Game) newObject(name string, scale float64, lifetime int) *ReactionObject {
 	obj := &ReactionObject{
-		x: x, y: y, vx: math.Cos(angle) * speed, vy: math.Sin(angle) * speed,
-		lifetime:     minLifetime + rand.Intn(maxLifetime-minLifetime),
-		reactionName: reaction.Name,
-		scale:        scale,
+		lifetime:       lifetime,
+		reactionName:   name,
+		scale:          scale,
+		flipped:        g.randomFlip && rand.Intn(2) == 0,
+		playOnce:       g.playOnce,
+		holdFirstFrame: g.holdFirst,
+		animSpeed:      g.animSpeed,
+		zeroDelay:      g.zeroDelay,
+		trailEnabled:   g.trail,
+		showLabel:      g.labels,
+
+		minVisibleTicks: g.minVisibleTicks,
+		restitution:     g.restitution,
+		fit:             g.fit,
+		effect:          g.effectFor(name),
+	}
+	if g.wobble > 0 {
+		obj.wobblePhase = rand.Float64() * 2 * math.Pi
+		obj.wobbleAmp = g.wobble * (0.5 + rand.Float64()/2)
+	}
+	if g.lifetimeFade {
+		obj.spawnLifetime = lifetime
+	}
+	if g.entrance != entranceNone {
+		obj.appearTicks = appearDuration
+		obj.appearFade = g.entrance == entranceFade
 	}
+	return obj
+}
+
+// effectFor returns the effect configured for the named reaction. Local custom
+// emojis arrive as ":name@.:" and match the effect configured for ":name:".
+func (g *Game) effectFor(name string) objectEffect {
+	if effect, ok := g.effects[name]; ok {
+		return effect
+	}
+	return g.effects[strings.Replace(name, "@.:", ":", 1)]
+}
+
+// addObject adds obj to the screen and queues its image to be loaded in the background.
+func (g *Game) addObject(obj *ReactionObject, reaction ReactionInfo) {
+	obj.reactionURL = reaction.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	obj.cancelLoad = cancel
 	g.objects = append(g.objects, obj)
+	metrics.reactionsSpawned.Inc()
 
-	go g.imageManager.LoadImageForObject(obj, reaction)
+	g.imageManager.Enqueue(ctx, obj, reaction)
+}
+
+// spawnGroupMembers arranges the other reactions on a note in a row around leader,
+// alternating right and left so the row stays centered on the leader.
+func (g *Game) spawnGroupMembers(leader *ReactionObject, group []ReactionInfo) {
+	spacing := 2 * objectHalfSize * leader.scale
+	for i, member := range group {
+		if len(g.objects) >= g.quality.objectLimit() {
+			metrics.reactionsDropped.Inc()
+			return
+		}
+		offset := float64(i/2+1) * spacing
+		if i%2 == 1 {
+			offset = -offset
+		}
+		obj := g.newObject(member.Name, leader.scale, leader.lifetime)
+		obj.x, obj.y = leader.x+offset, leader.y
+		obj.leader = leader
+		obj.groupOffset = offset
+		leader.groupHalfWidth = max(leader.groupHalfWidth, math.Abs(offset))
+		g.addObject(obj, member)
+	}
+}
+
+// popReaction starts the pop animation on the newest object showing the named
+// reaction. Nothing happens if no such object is on screen.
+func (g *Game) popReaction(name string) {
+	for i := len(g.objects) - 1; i >= 0; i-- {
+		o := g.objects[i]
+		if o.reactionName == name && o.popTicks == 0 {
+			o.popTicks = popDuration
+			return
+		}
+	}
+}
+
+// remember adds reaction to the history replayed by burstKey, moving it to the
+// end if a reaction with the same name is already there.
+func (g *Game) remember(reaction ReactionInfo) {
+	if g.burstSize <= 0 {
+		return
+	}
+	reaction.Group = nil // Replay just the reaction itself
+	g.history = slices.DeleteFunc(g.history, func(r ReactionInfo) bool { return r.Name == reaction.Name })
+	g.history = append(g.history, reaction)
+	if len(g.history) > g.burstSize {
+		g.history = g.history[len(g.history)-g.burstSize:]
+	}
+}
+
+// newLifetime returns the lifetime in ticks for a newly spawned object.
+func (g *Game) newLifetime() int {
+	if g.lifetime > 0 {
+		return g.lifetime
+	}
+	return minLifetime + rand.Intn(maxLifetime-minLifetime)
+}
+
+// handleReaction spawns a reaction taken from the channel, or pops the matching
+// object off the screen if the reaction was removed.
+func (g *Game) handleReaction(reaction ReactionInfo, bounds image.Rectangle) {
+	metrics.reactionsReceived.Inc()
+	if g.maxAge > 0 && !reaction.ReceivedAt.IsZero() && time.Since(reaction.ReceivedAt) > g.maxAge {
+		// Drop stale reactions left over from a backlog so the overlay stays live.
+		metrics.reactionsDropped.Inc()
+		return
+	}
+	if reaction.Removed {
+		g.popReaction(reaction.Name)
+		return
+	}
+	g.spawnReaction(reaction, bounds)
+	g.remember(reaction)
+	g.countCombo(reaction, bounds)
+}
+
+// bounds returns the area objects move in: the configured region, or the whole
+// screen when no region is set. Both are in the device pixels of the screen
+// returned by Layout, which objects are drawn on.
+func (g *Game) bounds() image.Rectangle {
+	if !g.region.Empty() {
+		return g.region
+	}
+	return image.Rect(0, 0, g.screenWidth, g.screenHeight)
 }
 
 // Update proceeds the game state.
 func (g *Game) Update() error {
-	w, h := ebiten.WindowSize()
-	select {
-	case reaction := <-g.reactionChan:
-		g.spawnReaction(reaction, w, h)
-	default:
+	bounds := g.bounds()
+	g.quality.update()
+	if inpututil.IsKeyJustPressed(ebiten.KeyF12) {
+		if err := g.saveSnapshot(); err != nil {
+			log.Printf("Failed to save snapshot: %v", err)
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF10) {
+		if err := writeDiagnostics(g.configPath, true); err != nil {
+			log.Printf("Failed to write diagnostics: %v", err)
+		}
+	}
+	if g.burstSize > 0 && inpututil.IsKeyJustPressed(g.burstKey) {
+		for _, reaction := range g.history {
+			g.spawnReaction(reaction, bounds)
+		}
+	}
+	// Take up to maxSpawnsPerTick reactions. Once the screen is full, the rest
+	// stay queued instead of being dropped.
+drain:
+	for i := 0; i < g.maxSpawnsPerTick; i++ {
+		if i > 0 && len(g.objects) >= g.quality.objectLimit() {
+			break
+		}
+		select {
+		case reaction := <-g.reactionChan:
+			g.handleReaction(reaction, bounds)
+		default:
+			break drain
+		}
 	}
 
 	nextObjects := make([]*ReactionObject, 0, len(g.objects))
 	for _, o := range g.objects {
-		if o.Update(w, h) {
+		if o.Update(bounds) {
 			nextObjects = append(nextObjects, o)
+		} else {
+			o.removed = true
+			if o.cancelLoad != nil { // Combo labels have no image to load
+				o.cancelLoad()
+			}
 		}
 	}
 	g.objects = nextObjects
+	g.imageManager.disposeEvicted(g.objects)
+	metrics.activeObjects.Set(len(g.objects))
 	return nil
 }
 
 // Draw draws the game screen.
+// The screen is cleared here rather than by Ebitengine (see main), so every frame
+// starts from a known state even on compositors that flicker with implicit clears.
 func (g *Game) Draw(screen *ebiten.Image) {
-	for _, o := range g.objects {
-		o.Draw(screen)
+	switch {
+	case g.opaqueClear:
+		screen.Fill(color.Black)
+	case g.dim > 0:
+		// The screen stays transparent; this only darkens what is behind the window.
+		screen.Fill(color.RGBA{A: uint8(g.dim * 255)})
+	default:
+		screen.Clear()
 	}
+	target := screen
+	var scene, blurred *ebiten.Image
+	if g.bloom > 0 {
+		scene, blurred = g.bloomBuffers(screen.Bounds())
+		target = scene
+	}
+	if !g.region.Empty() {
+		// Clip to the region so objects entering or leaving it aren't drawn outside.
+		target = target.SubImage(g.region).(*ebiten.Image)
+	}
+	g.drawOps.noTrails = !g.quality.trails()
+	g.drawOps.noLabels = !g.quality.labels()
+	for i := range g.objects {
+		g.objectInDrawOrder(i).Draw(target, &g.drawOps)
+	}
+	if g.bloom > 0 {
+		g.drawBloom(screen, scene, blurred)
+	}
+	if g.counter {
+		g.drawCounter(screen)
+	}
+}
+
+// objectInDrawOrder returns the i-th object to draw. Objects drawn later end up
+// on top, so newer objects cover older ones unless oldestOnTop is set.
+func (g *Game) objectInDrawOrder(i int) *ReactionObject {
+	if g.oldestOnTop {
+		return g.objects[len(g.objects)-1-i]
+	}
+	return g.objects[i]
+}
+
+// drawCounter draws the session uptime and the number of reactions received so
+// far in the top-left corner, with a shadow like labels.
+func (g *Game) drawCounter(screen *ebiten.Image) {
+	uptime := time.Since(g.startedAt).Truncate(time.Second)
+	s := fmt.Sprintf("Uptime %s  Reactions %d", uptime, metrics.reactionsReceived.Value())
+
+	op := &g.drawOps.text
+	op.GeoM.Reset()
+	op.GeoM.Translate(11, 11)
+	op.ColorScale.Reset()
+	op.ColorScale.ScaleWithColor(color.Black)
+	text.Draw(screen, s, fallbackFont, op)
+
+	op.GeoM.Reset()
+	op.GeoM.Translate(10, 10)
+	op.ColorScale.Reset()
+	text.Draw(screen, s, fallbackFont, op)
 }
 
 // Layout takes the outside size (e.g., the window size) and returns the (logical) screen size.
+// Fractional sizes are rounded up so no row of device pixels is lost, and the size
+// never drops below 1x1, since a minimized window may report 0x0.
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	s := ebiten.Monitor().DeviceScaleFactor()
-	return int(float64(outsideWidth) * s), int(float64(outsideHeight) * s)
+	s := deviceScaleFactor()
+	w := int(math.Ceil(float64(outsideWidth) * s))
+	h := int(math.Ceil(float64(outsideHeight) * s))
+	g.screenWidth, g.screenHeight = w, h // Left at 0 so bounds() is empty and nothing spawns
+	return max(1, w), max(1, h)
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// BenchmarkDraw measures the CPU cost of Game.Draw for a full screen of loaded
+// reactions with trails and labels, and its allocations. No game loop runs, so
+// Ebitengine only queues the draw commands; flushing them to the GPU is not
+// measured.
+func BenchmarkDraw(b *testing.B) {
+	if err := setup(""); err != nil {
+		b.Fatal(err)
+	}
+	g := newTestGame(b, nil)
+	img := ebiten.NewImage(64, 64)
+	for i := range maxObjects {
+		g.spawnReaction(ReactionInfo{Name: fmt.Sprintf(":r%d:", i)}, g.bounds())
+	}
+	for _, o := range g.objects {
+		o.image = img
+		o.showLabel = true
+		o.trailEnabled = true
+	}
+	for range trailLength {
+		if err := g.Update(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	screen := ebiten.NewImage(800, 600)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		g.Draw(screen)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+)
+
+// stubMisskey resolves every custom emoji to a made-up URL, or fails with
+// queryErr if set. Only the emojis in custom are listed by the instance.
+type stubMisskey struct {
+	queryErr error
+	custom   map[string]bool
+}
+
+func (s *stubMisskey) Connect(ctx context.Context, reactionChan chan<- ReactionInfo) {}
+
+func (s *stubMisskey) QueryEmojiAPI(emojiName string) (string, error) {
+	if s.queryErr != nil {
+		return "", s.queryErr
+	}
+	return "https://example.com/emoji/" + emojiName + ".png", nil
+}
+
+func (s *stubMisskey) HasEmoji(emojiName string) bool { return s.custom[emojiName] }
+
+func (s *stubMisskey) RefreshEmojiURL(emojiName string) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (s *stubMisskey) MediaToken(mediaURL string) string { return "" }
+
+// setScale fakes a monitor with the given scale factor for the duration of the test.
+func setScale(t testing.TB, scale float64) {
+	t.Helper()
+	old := deviceScaleFactor
+	deviceScaleFactor = func() float64 { return scale }
+	t.Cleanup(func() { deviceScaleFactor = old })
+}
+
+// newTestGame returns a game laid out in an 800x600 window at scale 1,
+// receiving reactions on rc. Its image manager runs no workers, so nothing is
+// loaded.
+func newTestGame(t testing.TB, rc <-chan ReactionInfo) *Game {
+	t.Helper()
+	setScale(t, 1)
+	g := NewGame(rc, NewImageManager(&stubMisskey{}))
+	g.Layout(800, 600)
+	return g
+}
+
+func TestSpawnReactionRespectsObjectLimit(t *testing.T) {
+	g := newTestGame(t, nil)
+	for i := range 3 * maxObjects {
+		g.spawnReaction(ReactionInfo{Name: fmt.Sprintf(":r%d:", i)}, g.bounds())
+		if len(g.objects) > maxObjects {
+			t.Fatalf("after %d reactions: %d objects, want at most %d", i+1, len(g.objects), maxObjects)
+		}
+	}
+	if len(g.objects) != maxObjects {
+		t.Errorf("got %d objects, want the screen filled with %d", len(g.objects), maxObjects)
+	}
+}
+
+// In grid layout, new reactions replace the oldest instead of being dropped.
+func TestGridKeepsNewestReaction(t *testing.T) {
+	g := newTestGame(t, nil)
+	g.grid = true
+	for i := range 3 * maxObjects {
+		name := fmt.Sprintf(":r%d:", i)
+		g.spawnReaction(ReactionInfo{Name: name}, g.bounds())
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		settled := 0 // Objects not popping off the screen
+		for _, o := range g.objects {
+			if o.popTicks == 0 {
+				settled++
+			}
+		}
+		if settled > maxObjects {
+			t.Fatalf("after %d reactions: %d objects, want at most %d", i+1, settled, maxObjects)
+		}
+		if newest := g.objects[len(g.objects)-1]; newest.reactionName != name {
+			t.Fatalf("newest object is %s, want %s", newest.reactionName, name)
+		}
+	}
+}
+
+func TestNewGame(t *testing.T) {
+	rc := make(chan ReactionInfo)
+	im := NewImageManager(&stubMisskey{})
+	g := NewGame(rc, im)
+	if g == nil {
+		t.Fatal("NewGame returned nil")
+	}
+	if g.reactionChan != (<-chan ReactionInfo)(rc) {
+		t.Error("reactionChan is not set")
+	}
+	if g.imageManager != im {
+		t.Error("imageManager is not set")
+	}
+	if g.maxSpawnsPerTick != 1 {
+		t.Errorf("maxSpawnsPerTick = %d, want 1", g.maxSpawnsPerTick)
+	}
+}
+
+func TestUpdateDrainsOneReactionPerTick(t *testing.T) {
+	rc := make(chan ReactionInfo, 3)
+	g := newTestGame(t, rc)
+	for i := range 3 {
+		rc <- ReactionInfo{Name: fmt.Sprintf(":r%d:", i)}
+	}
+	for i := range 3 {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(rc), 2-i; got != want {
+			t.Fatalf("after %d updates: %d reactions queued, want %d", i+1, got, want)
+		}
+		if got, want := len(g.objects), i+1; got != want {
+			t.Fatalf("after %d updates: %d objects, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestUpdateDrainsUpToMaxSpawnsPerTick(t *testing.T) {
+	rc := make(chan ReactionInfo, 10)
+	g := newTestGame(t, rc)
+	g.maxSpawnsPerTick = 4
+	for i := range 10 {
+		rc <- ReactionInfo{Name: fmt.Sprintf(":r%d:", i)}
+	}
+	for _, want := range []int{4, 8, 10} {
+		if err := g.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if len(g.objects) != want {
+			t.Fatalf("%d objects, want %d", len(g.objects), want)
+		}
+	}
+	if len(rc) != 0 {
+		t.Errorf("%d reactions still queued", len(rc))
+	}
+}
+
+func TestUpdateDrainStopsAtObjectLimit(t *testing.T) {
+	rc := make(chan ReactionInfo, 10)
+	g := newTestGame(t, rc)
+	g.maxSpawnsPerTick = 10
+	for i := range maxObjects - 2 {
+		g.spawnReaction(ReactionInfo{Name: fmt.Sprintf(":old%d:", i)}, g.bounds())
+	}
+	for i := range 10 {
+		rc <- ReactionInfo{Name: fmt.Sprintf(":r%d:", i)}
+	}
+	if err := g.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.objects) != maxObjects {
+		t.Errorf("%d objects, want %d", len(g.objects), maxObjects)
+	}
+	if got, want := len(rc), 8; got != want {
+		t.Errorf("%d reactions queued, want %d left for later ticks", got, want)
+	}
+}
+
+func TestObjectInDrawOrder(t *testing.T) {
+	g := newTestGame(t, nil)
+	for i := range 3 {
+		g.spawnReaction(ReactionInfo{Name: fmt.Sprintf(":r%d:", i)}, g.bounds())
+	}
+	oldest, newest := g.objects[0], g.objects[2]
+	for _, tt := range []struct {
+		oldestOnTop bool
+		first, last *ReactionObject
+	}{
+		{false, oldest, newest},
+		{true, newest, oldest},
+	} {
+		g.oldestOnTop = tt.oldestOnTop
+		if g.objectInDrawOrder(0) != tt.first || g.objectInDrawOrder(2) != tt.last {
+			t.Errorf("oldestOnTop = %v: drawn in the wrong order", tt.oldestOnTop)
+		}
+		if g.objectInDrawOrder(1) != g.objects[1] {
+			t.Errorf("oldestOnTop = %v: middle object out of place", tt.oldestOnTop)
+		}
+	}
+}
+
+// TestCoordinateSpace pins down that spawning, drawing and bouncing all work
+// in the device pixels of the screen returned by Layout, whatever the scale.
+func TestCoordinateSpace(t *testing.T) {
+	for _, scale := range []float64{1, 2} {
+		t.Run(fmt.Sprint(scale), func(t *testing.T) {
+			setScale(t, scale)
+			g := NewGame(nil, NewImageManager(&stubMisskey{}))
+			sw, sh := g.Layout(400, 300)
+			if want := int(400 * scale); sw != want {
+				t.Fatalf("screen width = %d, want %d", sw, want)
+			}
+			if got, want := g.bounds(), image.Rect(0, 0, sw, sh); got != want {
+				t.Errorf("bounds = %v, want the visible screen %v", got, want)
+			}
+
+			// Outward spawns start at the center of the screen.
+			g.direction = directionOutward
+			g.spawnReaction(ReactionInfo{Name: ":r:"}, g.bounds())
+			o := g.objects[0]
+			if o.x != float64(sw)/2 || o.y != float64(sh)/2 {
+				t.Errorf("spawned at (%v, %v), want the screen center (%v, %v)", o.x, o.y, float64(sw)/2, float64(sh)/2)
+			}
+
+			// The image is drawn centered on the object, scaled by the device scale.
+			o.scale, o.angle = 1, 0
+			m := o.imageGeoM(72, 72, deviceScaleFactor())
+			if x, y := m.Apply(36, 36); x != o.x || y != o.y {
+				t.Errorf("image center drawn at (%v, %v), want (%v, %v)", x, y, o.x, o.y)
+			}
+			x0, _ := m.Apply(0, 0)
+			x1, _ := m.Apply(72, 0)
+			if got, want := x1-x0, 72*scale; math.Abs(got-want) > 1e-9 {
+				t.Errorf("image drawn %v pixels wide, want %v", got, want)
+			}
+
+			// An object reaching the right edge of the screen bounces back.
+			o.x, o.y, o.vx, o.vy = float64(sw)-objectHalfSize+1, float64(sh)/2, 1, 0
+			o.Update(g.bounds())
+			if o.vx >= 0 {
+				t.Errorf("object at the right edge still moves right, vx = %v", o.vx)
+			}
+		})
+	}
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		scale        float64
+		w, h         int
+		wantW, wantH int
+	}{
+		{1, 0, 0, 1, 1},
+		{2, 0, 0, 1, 1},
+		{1, 801, 601, 801, 601},
+		{2, 801, 601, 1602, 1202},
+		{1.25, 801, 601, 1002, 752}, // 1001.25 x 751.25, rounded up
+		{1.5, 801, 601, 1202, 902},  // 1201.5 x 901.5, rounded up
+		{1.5, 1, 1, 2, 2},
+	}
+	for _, tt := range tests {
+		setScale(t, tt.scale)
+		g := NewGame(nil, nil)
+		if w, h := g.Layout(tt.w, tt.h); w != tt.wantW || h != tt.wantH {
+			t.Errorf("Layout(%d, %d) at scale %v = %d x %d, want %d x %d", tt.w, tt.h, tt.scale, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// A minimized window reports 0x0. Reactions arriving then are dropped rather
+// than spawned into a degenerate area.
+func TestZeroSizeWindowDropsReactions(t *testing.T) {
+	rc := make(chan ReactionInfo, 1)
+	g := newTestGame(t, rc)
+	g.Layout(0, 0)
+	rc <- ReactionInfo{Name: ":r:"}
+	if err := g.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.objects) != 0 {
+		t.Errorf("%d objects spawned in a 0x0 window, want none", len(g.objects))
+	}
+}
+
+func TestBounce(t *testing.T) {
+	tests := []struct {
+		v, restitution, want float64
+	}{
+		{0.1, 1, -0.1},
+		{-0.1, 1, 0.1},
+		{2, 0.5, -1},
+		{0.1, 0.5, -minBounceSpeed},
+		{-0.1, 0.5, minBounceSpeed},
+		{4, 1.2, -maxBounceSpeed},
+	}
+	for _, tt := range tests {
+		if got := bounce(tt.v, tt.restitution); got != tt.want {
+			t.Errorf("bounce(%v, %v) = %v, want %v", tt.v, tt.restitution, got, tt.want)
+		}
+	}
+}
+
+func TestBounceAxis(t *testing.T) {
+	tests := []struct {
+		name        string
+		pos, v, pad float64
+		lo, hi      float64
+		want        float64
+	}{
+		{"inside", 50, 1, 10, 0, 100, 1},
+		{"touching the right wall heading out", 90, 1, 10, 0, 100, 1},
+		{"past the right wall heading out", 91, 1, 10, 0, 100, -1},
+		{"past the right wall heading back in", 91, -1, 10, 0, 100, -1},
+		{"touching the left wall heading out", 10, -1, 10, 0, 100, -1},
+		{"past the left wall heading out", 9, -1, 10, 0, 100, 1},
+		{"zero velocity past a wall", 95, 0, 10, 0, 100, 0},
+		{"oversized inside its sweep", 50, 1, 80, 0, 100, 1},
+		{"oversized past its sweep", 81, 1, 80, 0, 100, -1},
+	}
+	for _, tt := range tests {
+		if got := bounceAxis(tt.pos, tt.v, tt.pad, tt.lo, tt.hi, 1); got != tt.want {
+			t.Errorf("%s: bounceAxis(%v, %v, %v, %v, %v) = %v, want %v", tt.name, tt.pos, tt.v, tt.pad, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+// An object larger than the bounds sweeps between its walls, reversing once
+// per crossing instead of every tick.
+func TestBounceAxisOversizedDoesNotFlipFlop(t *testing.T) {
+	pos, v := 50.0, 1.0
+	reversals := 0
+	for range 200 {
+		pos += v
+		next := bounceAxis(pos, v, 80, 0, 100, 1)
+		if next != v {
+			reversals++
+		}
+		v = next
+		if pos < 20-1 || pos > 80+1 {
+			t.Fatalf("pos = %v, want it to stay within its sweep of [20, 80]", pos)
+		}
+	}
+	if reversals < 2 || reversals > 200/60+1 {
+		t.Errorf("%d reversals in 200 ticks, want one per 60-pixel crossing", reversals)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "image"
+
+// gridCellSize is the width and height of a grid cell, fitting one unscaled object.
+const gridCellSize = 2 * objectHalfSize
+
+// spawnGridReaction places the reaction in the next grid cell, filling rows from
+// the top-left corner of bounds. Once every cell is taken, the oldest object pops
+// off and its cell is reused.
+func (g *Game) spawnGridReaction(reaction ReactionInfo, bounds image.Rectangle) {
+	cols := max(1, int(float64(bounds.Dx())/gridCellSize))
+	rows := max(1, int(float64(bounds.Dy())/gridCellSize))
+	cells := min(cols*rows, g.quality.objectLimit())
+
+	if g.gridCells == nil {
+		g.gridCells = make(map[int]*ReactionObject)
+	}
+	cell := g.gridNext % cells
+	g.gridNext = cell + 1
+	if old := g.gridCells[cell]; old != nil && !old.removed && old.popTicks == 0 {
+		old.popTicks = popDuration
+	}
+
+	// Grid objects never move or leave the bounds, so they stay until replaced.
+	obj := g.newObject(reaction.Name, 1, g.newLifetime())
+	obj.spawnLifetime = 0 // Don't fade out while waiting to be replaced
+	obj.x = float64(bounds.Min.X) + (float64(cell%cols)+0.5)*gridCellSize
+	obj.y = float64(bounds.Min.Y) + (float64(cell/cols)+0.5)*gridCellSize
+	if g.entrance == entranceNone {
+		obj.appearTicks = appearDuration // Grid objects always get an entrance
+	}
+	g.gridCells[cell] = obj
+	g.addObject(obj, reaction)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// decodeICO decodes the largest image stored in an ICO file. Entries may be
+// embedded PNGs or uncompressed 24/32-bit DIBs, which covers most favicons.
+func decodeICO(data []byte) (image.Image, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[0:2]) != 0 || binary.LittleEndian.Uint16(data[2:4]) != 1 {
+		return nil, fmt.Errorf("ico: invalid header")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 || len(data) < 6+count*16 {
+		return nil, fmt.Errorf("ico: invalid directory")
+	}
+
+	// Pick the entry with the most pixels, preferring higher bit depths on ties.
+	best, bestArea, bestBits := -1, 0, 0
+	for i := 0; i < count; i++ {
+		entry := data[6+i*16 : 6+(i+1)*16]
+		w, h := int(entry[0]), int(entry[1])
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		bits := int(binary.LittleEndian.Uint16(entry[6:8]))
+		if area := w * h; area > bestArea || (area == bestArea && bits > bestBits) {
+			best, bestArea, bestBits = i, area, bits
+		}
+	}
+
+	entry := data[6+best*16 : 6+(best+1)*16]
+	size := int(binary.LittleEndian.Uint32(entry[8:12]))
+	offset := int(binary.LittleEndian.Uint32(entry[12:16]))
+	if offset < 0 || size < 0 || offset+size > len(data) {
+		return nil, fmt.Errorf("ico: image data out of range")
+	}
+	imgData := data[offset : offset+size]
+
+	if bytes.HasPrefix(imgData, []byte("\x89PNG\r\n\x1a\n")) {
+		return png.Decode(bytes.NewReader(imgData))
+	}
+	return decodeICODIB(imgData)
+}
+
+// decodeICODIB decodes a bottom-up 24 or 32-bit BI_RGB bitmap as stored in an
+// ICO file: the height covers both the color data and the 1-bit AND mask.
+func decodeICODIB(data []byte) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("ico: bitmap header too short")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:8])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:12]))) / 2
+	bits := int(binary.LittleEndian.Uint16(data[14:16]))
+	compression := binary.LittleEndian.Uint32(data[16:20])
+	if headerSize < 40 || headerSize > len(data) {
+		return nil, fmt.Errorf("ico: invalid bitmap header size %d", headerSize)
+	}
+	if width <= 0 || height <= 0 || width > 256 || height > 256 {
+		return nil, fmt.Errorf("ico: unsupported bitmap size %dx%d", width, height)
+	}
+	if compression != 0 || (bits != 24 && bits != 32) {
+		return nil, fmt.Errorf("ico: unsupported %d-bit bitmap (compression %d)", bits, compression)
+	}
+
+	bytesPerPixel := bits / 8
+	stride := (width*bytesPerPixel + 3) &^ 3
+	maskStride := ((width + 31) / 32) * 4
+	pixels := data[headerSize:]
+	if len(pixels) < stride*height {
+		return nil, fmt.Errorf("ico: bitmap data too short")
+	}
+	mask := pixels[stride*height:]
+	hasMask := len(mask) >= maskStride*height
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := pixels[(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*bytesPerPixel:]
+			c := color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+			if bytesPerPixel == 4 {
+				c.A = p[3]
+				hasAlpha = hasAlpha || c.A != 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// Without an alpha channel, transparency comes from the AND mask.
+	if !hasAlpha && hasMask {
+		for y := 0; y < height; y++ {
+			row := mask[(height-1-y)*maskStride:]
+			for x := 0; x < width; x++ {
+				a := uint8(0xff)
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					a = 0
+				}
+				img.Pix[img.PixOffset(x, y)+3] = a
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// icoDIB returns a 2x2 32-bit bitmap as stored in an ICO entry, with the given
+// header size field and the pixel data cut to pixelBytes.
+func icoDIB(headerSize uint32, pixelBytes int) []byte {
+	data := make([]byte, 40)
+	binary.LittleEndian.PutUint32(data[0:4], headerSize)
+	binary.LittleEndian.PutUint32(data[4:8], 2)
+	binary.LittleEndian.PutUint32(data[8:12], 4) // Color data and AND mask
+	binary.LittleEndian.PutUint16(data[12:14], 1)
+	binary.LittleEndian.PutUint16(data[14:16], 32)
+	for range pixelBytes {
+		data = append(data, 0xff)
+	}
+	return data
+}
+
+func TestDecodeICODIB(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"valid", icoDIB(40, 2*2*4), ""},
+		{"header size too small", icoDIB(8, 2*2*4), "header size"},
+		{"header size past the end", icoDIB(1<<20, 2*2*4), "header size"},
+		{"header size 0xffffffff", icoDIB(0xffffffff, 2*2*4), "header size"},
+		{"pixels cut short", icoDIB(40, 2*2*4-1), "too short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := decodeICODIB(tt.data)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if img.Bounds().Dx() != 2 || img.Bounds().Dy() != 2 {
+					t.Errorf("bounds = %v, want 2x2", img.Bounds())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"container/list"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
@@ -12,99 +15,533 @@ import (
 	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gen2brain/webp"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/kettek/apng"
+	xdraw "golang.org/x/image/draw"
 )
 
+// maxRedirects is the longest redirect chain followed when fetching an image.
+const maxRedirects = 5
+
+// fetchTimeout bounds a whole image fetch, including reading the body, so a
+// hanging host can't hold on to a worker forever.
+const fetchTimeout = 10 * time.Second
+
+// retryDelays are the waits before retrying a fetch that failed with a server
+// error or a broken connection. Each further retry waits longer, and a fetch is
+// attempted at most len(retryDelays)+1 times, which is 3.
+var retryDelays = []time.Duration{100 * time.Millisecond, 400 * time.Millisecond}
+
+// userAgent is sent with image fetches, since some instances reject Go's default.
+const userAgent = "misskey-reactions/" + version + " (+https://github.com/yulog/ebiten-sandbox)"
+
+// httpClient is shared by all image fetches.
+var httpClient = &http.Client{
+	Timeout: fetchTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			log.Printf("Stopped after %d redirects fetching %s", len(via), via[0].URL)
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		return nil
+	},
+}
+
+// maxImageSize is the largest width or height uploaded to the GPU. Larger images
+// are downscaled on the CPU first, since emojis are drawn small anyway. 0 disables it.
+var maxImageSize = 256
+
+// uploadImage copies a decoded image to the GPU. It is a variable so decoding
+// can be exercised without a graphics driver.
+var uploadImage = ebiten.NewImageFromImage
+
+// maxCanvasSize is the largest width or height an image may declare. Larger
+// images are rejected before anything is allocated for them.
+var maxCanvasSize = 4096
+
+// staticOnly makes animated images show only their first frame.
+var staticOnly bool
+
+// staticFormats holds the animated formats, as named by animatedFormat, whose
+// images show only their first frame. It is set from the config.
+var staticFormats = map[string]bool{}
+
+// failureLogInterval is how often a failure is logged again for the same URL.
+const failureLogInterval = time.Minute
+
+// fetchFailures rate-limits the log lines of failed image loads, so a flaky
+// network doesn't flood the log with the same emoji over and over.
+var fetchFailures = failureLog{entries: make(map[string]*failureLogEntry)}
+
+// failureLog logs at most one line per key every failureLogInterval and
+// reports how many lines it suppressed in between.
+type failureLog struct {
+	mu      sync.Mutex
+	entries map[string]*failureLogEntry
+}
+
+type failureLogEntry struct {
+	logged     time.Time
+	suppressed int
+}
+
+// Printf logs like log.Printf unless a line for key was logged within the last
+// failureLogInterval, in which case the line is only counted.
+func (l *failureLog) Printf(key, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if e, ok := l.entries[key]; ok && now.Sub(e.logged) < failureLogInterval {
+		e.suppressed++
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if e, ok := l.entries[key]; ok && e.suppressed > 0 {
+		msg += fmt.Sprintf(" (%d similar failures suppressed)", e.suppressed)
+	}
+	log.Print(msg)
+
+	// Forget keys that have been quiet for a whole interval, so the map stays
+	// small, summing up what was suppressed for them.
+	for k, e := range l.entries {
+		if k != key && now.Sub(e.logged) >= failureLogInterval {
+			if e.suppressed > 0 {
+				log.Printf("Suppressed %d similar failures for %s", e.suppressed, k)
+			}
+			delete(l.entries, k)
+		}
+	}
+	l.entries[key] = &failureLogEntry{logged: now}
+}
+
+// fetchSlots and decodeSlots bound the number of concurrent downloads and decodes
+// separately, since animations allocate large canvases while they are decoded.
+var (
+	fetchSlots  = make(chan struct{}, 8)
+	decodeSlots = make(chan struct{}, 2)
+)
+
+// twemojiURL is the URL template for Twemoji images, with %s standing for the
+// dash-separated code points. It is set from defaultTwemojiURL or -twemoji-url
+// by twemojiTemplate.
+var twemojiURL = "https://cdn.jsdelivr.net/gh/twitter/twemoji@latest/assets/72x72/%s.png"
+
+// defaultTwemojiURL is the official Twemoji CDN, with {size} standing for the
+// PNG size. The CDN only hosts 72x72 PNGs and SVGs, so larger sizes need a
+// mirror that serves pre-rendered PNGs.
+const defaultTwemojiURL = "https://cdn.jsdelivr.net/gh/twitter/twemoji@latest/assets/{size}x{size}/%s.png"
+
+// twemojiTemplate returns the twemojiURL for Twemoji PNGs of the given size in
+// pixels, filled into the {size} placeholders of urlTemplate. SVG Twemoji are
+// rejected, since there is no SVG decoder.
+func twemojiTemplate(urlTemplate, size string) (string, error) {
+	if strings.EqualFold(size, "svg") {
+		return "", fmt.Errorf("SVG images cannot be decoded, use a PNG size such as 72")
+	}
+	if n, err := strconv.Atoi(size); err != nil || n < 1 {
+		return "", fmt.Errorf("invalid size %q: must be a number of pixels such as 72", size)
+	}
+	if strings.Count(urlTemplate, "%s") != 1 || strings.Count(urlTemplate, "%") != 1 {
+		return "", fmt.Errorf("invalid URL template %q: must contain %%s exactly once", urlTemplate)
+	}
+	return strings.ReplaceAll(urlTemplate, "{size}", size), nil
+}
+
+// errNotFound is wrapped by fetchAndDecodeImage when the server responds with 404.
+var errNotFound = errors.New("image not found")
+
+// errTimeout is wrapped by fetchAndDecodeImage when the fetch takes longer than fetchTimeout.
+var errTimeout = errors.New("image fetch timed out")
+
+// errServer is wrapped by fetchAndDecodeImage when the server responds with a 5xx status.
+var errServer = errors.New("server error")
+
+// errExpired is wrapped by fetchAndDecodeImage when the server responds with 403
+// or 410, as media proxies do once a signed URL has expired.
+var errExpired = errors.New("image URL expired")
+
+// defaultCacheSize is the number of images kept in the cache by default.
+const defaultCacheSize = 200
+
 // ImageManager handles caching and decoding of images.
 type ImageManager struct {
-	cache         map[string]any
+	cache         map[string]*list.Element // Elements of cacheOrder, holding a cacheEntry
+	cacheOrder    *list.List               // Most recently used first
+	cacheSize     int                      // Largest number of cached images, 0 means unlimited
+	evicted       []any                    // Images dropped from the cache that are yet to be disposed
+	loads         map[string]*pendingLoad  // Images being loaded, by reaction name
 	cacheMutex    *sync.RWMutex
 	misskeyClient MisskeyAPI
+	fallbackImage *ebiten.Image // Shown when an emoji fails to load, nil uses fallback text only
+	queue         chan loadRequest
+	mediaProxy    string     // URL template image requests are routed through, see Config.MediaProxy
+	disk          *diskCache // Keeps fetched images between runs, nil disables it
+
+	// fetch replaces fetchAndDecodeImage when set, so tests can load images
+	// without a network or a GPU.
+	fetch func(ctx context.Context, url, token string) (*DecodedImage, error)
+
+	// waiting and loaded, when set, are called by load workers once obj starts
+	// waiting for another object's load of the same image, and once its load
+	// request has been handled. Tests use them to sequence loads.
+	waiting, loaded func(obj *ReactionObject)
+}
+
+// cacheEntry is a cached image (static or animated) with its key.
+type cacheEntry struct {
+	key   string
+	value any
+}
+
+// pendingLoad is an image being loaded for one object while other objects with
+// the same reaction wait for it. done is closed once loading has ended, with
+// failed set if the other objects should show their fallback as well.
+type pendingLoad struct {
+	done   chan struct{}
+	failed bool
+}
+
+// loadRequest is an object waiting for its image to be loaded by a worker.
+// ctx is cancelled once the object has left the screen.
+type loadRequest struct {
+	ctx      context.Context
+	obj      *ReactionObject
+	reaction ReactionInfo
 }
 
 // NewImageManager creates a new manager for image assets.
+// StartWorkers must be called before images can be loaded.
 func NewImageManager(mc MisskeyAPI) *ImageManager {
 	return &ImageManager{
-		cache:         make(map[string]any),
+		cache:         make(map[string]*list.Element),
+		cacheOrder:    list.New(),
+		loads:         make(map[string]*pendingLoad),
+		cacheSize:     defaultCacheSize,
 		cacheMutex:    &sync.RWMutex{},
 		misskeyClient: mc,
+		queue:         make(chan loadRequest, maxObjects),
+	}
+}
+
+// NewImageManagerWithCacheDir creates a manager like NewImageManager that also
+// keeps fetched images in dir, creating it if needed, so they are not downloaded
+// again after a restart.
+func NewImageManagerWithCacheDir(mc MisskeyAPI, dir string) (*ImageManager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create image cache directory: %w", err)
+	}
+	im := NewImageManager(mc)
+	im.disk = &diskCache{dir: dir}
+	return im, nil
+}
+
+// StartWorkers starts n goroutines that load queued images, bounding the number
+// of concurrent fetches and decodes.
+func (im *ImageManager) StartWorkers(n int) {
+	for range n {
+		go func() {
+			for req := range im.queue {
+				if req.ctx.Err() == nil {
+					im.LoadImageForObject(req.ctx, req.obj, req.reaction)
+				}
+				if im.loaded != nil {
+					im.loaded(req.obj)
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue queues obj for its image to be loaded by a worker. It never blocks:
+// when the queue is full, the object shows its fallback instead.
+func (im *ImageManager) Enqueue(ctx context.Context, obj *ReactionObject, reaction ReactionInfo) {
+	select {
+	case im.queue <- loadRequest{ctx, obj, reaction}:
+	default:
+		log.Printf("Image queue is full, using fallback for %s", reaction.Name)
+		metrics.fetchFailure.Inc()
+		im.useFallback(obj, strings.Trim(reaction.Name, ":"))
+	}
+}
+
+// LoadFallbackImage loads the placeholder image shown for reactions whose emoji
+// cannot be loaded. It must be called before any objects are spawned.
+func (im *ImageManager) LoadFallbackImage(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read fallback image: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot decode fallback image %s: %w", path, err)
+	}
+	im.fallbackImage = newImageFromImage(img)
+	return nil
+}
+
+// useFallback shows the fallback image on obj if one is configured, keeping text
+// for the label and for when no image is configured.
+func (im *ImageManager) useFallback(obj *ReactionObject, text string) {
+	obj.fallbackText = text
+	if im.fallbackImage != nil {
+		obj.image = im.fallbackImage
 	}
 }
 
 // LoadImageForObject handles the asynchronous fetching, decoding, and caching of a reaction image.
-func (im *ImageManager) LoadImageForObject(obj *ReactionObject, reaction ReactionInfo) {
-	// Check cache first
-	cachedItem, exists := im.Get(reaction.Name)
-	if exists {
-		if staticImg, ok := cachedItem.(*ebiten.Image); ok {
-			obj.image = staticImg
-		} else if anim, ok := cachedItem.(*AnimatedImage); ok {
-			obj.animatedImage = anim
+// Loading stops early without a fallback once ctx is cancelled.
+func (im *ImageManager) LoadImageForObject(ctx context.Context, obj *ReactionObject, reaction ReactionInfo) {
+	// Check cache first. If another object is loading the same image, wait for
+	// it instead of fetching it twice. Should that object be removed before
+	// loading finishes, one of the waiting objects takes over.
+	var load *pendingLoad
+	for {
+		var leader bool
+		if load, leader = im.claimLoad(obj, reaction.Name); leader {
+			break
+		}
+		if load == nil {
+			return // Found in the cache
+		}
+		if im.waiting != nil {
+			im.waiting(obj)
+		}
+		select {
+		case <-load.done:
+		case <-ctx.Done():
+			return
+		}
+		if load.failed {
+			im.useFallback(obj, strings.Trim(reaction.Name, ":"))
+			return
 		}
-		return
 	}
+	defer im.finishLoad(reaction.Name, load)
 
 	// Determine URL to fetch
 	urlToFetch := reaction.URL
+	var simplifiedURL string // Twemoji URL of the base emoji, tried if the full sequence is missing
+	var emojiName string     // Set when the URL was resolved from the API, so it can be resolved again
 	if urlToFetch == "" {
 		if len(reaction.Name) > 2 && reaction.Name[0] == ':' && reaction.Name[len(reaction.Name)-1] == ':' {
 			var err error
-			emojiName := strings.Trim(reaction.Name, ":")
-			urlToFetch, err = im.misskeyClient.QueryEmojiAPI(emojiName) // Use the client
+			emojiName = strings.Trim(reaction.Name, ":")
+			if emoji, ok := shortcodeEmojis[emojiName]; ok && !im.misskeyClient.HasEmoji(emojiName) {
+				// A standard emoji under its shortcode, no need to ask the instance.
+				// Custom emojis of the instance take precedence over the shortcodes.
+				urlToFetch, emojiName = emojiToTwemojiURL(emoji), ""
+			} else {
+				urlToFetch, err = im.misskeyClient.QueryEmojiAPI(emojiName) // Use the client
+			}
 			if err != nil {
-				log.Printf("Failed to query API for emoji '%s': %v", emojiName, err)
-				obj.fallbackText = emojiName
+				fetchFailures.Printf(reaction.Name, "Failed to query API for emoji '%s': %v", emojiName, err)
+				load.failed = true
+				im.useFallback(obj, emojiName)
 				return
 			}
 		} else {
 			urlToFetch = emojiToTwemojiURL(reaction.Name)
+			if base := baseEmoji(reaction.Name); base != "" && base != reaction.Name {
+				simplifiedURL = emojiToTwemojiURL(base)
+			}
 		}
 	}
 
 	// Fetch and decode the image
-	decoded, err := fetchAndDecodeImage(urlToFetch)
+	decoded, err := im.fetchAndDecode(ctx, im.proxied(urlToFetch), im.misskeyClient.MediaToken(urlToFetch))
+	if errors.Is(err, errNotFound) && simplifiedURL != "" && simplifiedURL != urlToFetch {
+		log.Printf("No Twemoji image for %s, retrying with the base emoji", reaction.Name)
+		decoded, err = im.fetchAndDecode(ctx, im.proxied(simplifiedURL), "")
+	}
+	if errors.Is(err, errExpired) && emojiName != "" {
+		log.Printf("Image URL for %s expired, resolving it again", reaction.Name)
+		if freshURL, qerr := im.misskeyClient.RefreshEmojiURL(emojiName); qerr == nil && freshURL != urlToFetch {
+			decoded, err = im.fetchAndDecode(ctx, im.proxied(freshURL), im.misskeyClient.MediaToken(freshURL))
+		}
+	}
+	if ctx.Err() != nil {
+		return // The object is gone, nobody will see the image
+	}
 	if err != nil {
-		log.Printf("Failed to fetch image for %s: %v. Using fallback text.", reaction.Name, err)
-		obj.fallbackText = strings.Trim(reaction.Name, ":")
+		fetchFailures.Printf(urlToFetch, "Failed to fetch image for %s: %v. Using fallback.", reaction.Name, err)
+		metrics.fetchFailure.Inc()
+		load.failed = true
+		im.useFallback(obj, strings.Trim(reaction.Name, ":"))
 		return
 	}
 
-	// Update object and cache
-	log.Printf("Successfully fetched image for %s", reaction.Name)
-	if decoded.Animated != nil {
-		im.Set(reaction.Name, decoded.Animated) // Use the manager
+	// Update object and cache. The object takes the image before it is cached, so
+	// disposeEvicted sees it in use should it be evicted right away.
+	if decoded.Animated != nil && len(decoded.Animated.Frames) > 0 {
 		obj.animatedImage = decoded.Animated
+		im.Set(reaction.Name, decoded.Animated) // Use the manager
 	} else if decoded.Static != nil {
-		im.Set(reaction.Name, decoded.Static) // Use the manager
 		obj.image = decoded.Static
+		im.Set(reaction.Name, decoded.Static) // Use the manager
+	} else {
+		// Never leave the object without anything to draw.
+		log.Printf("Image for %s has no frames. Using fallback.", reaction.Name)
+		metrics.fetchFailure.Inc()
+		load.failed = true
+		im.useFallback(obj, strings.Trim(reaction.Name, ":"))
+		return
+	}
+	log.Printf("Successfully fetched image for %s", reaction.Name)
+	metrics.fetchSuccess.Inc()
+}
+
+// fetchAndDecode loads the image at url with fetchAndDecodeImage, through the
+// disk cache if one is configured, or with fetch if set.
+func (im *ImageManager) fetchAndDecode(ctx context.Context, url, token string) (*DecodedImage, error) {
+	if im.fetch != nil {
+		return im.fetch(ctx, url, token)
+	}
+	return fetchAndDecodeImage(ctx, im.disk, url, token)
+}
+
+// proxied rewrites imageURL to go through the media proxy, if one is configured.
+// URLs already pointing at the proxy and URLs served by the instance itself,
+// which may need the access token, are left alone.
+func (im *ImageManager) proxied(imageURL string) string {
+	if im.mediaProxy == "" {
+		return imageURL
 	}
+	prefix, _, _ := strings.Cut(im.mediaProxy, "{url}")
+	if strings.HasPrefix(imageURL, prefix) || im.misskeyClient.MediaToken(imageURL) != "" {
+		return imageURL
+	}
+	return strings.ReplaceAll(im.mediaProxy, "{url}", url.QueryEscape(imageURL))
 }
 
-// Get retrieves an image (static or animated) from the cache.
+// Get retrieves an image (static or animated) from the cache and marks it as
+// the most recently used.
 func (im *ImageManager) Get(key string) (any, bool) {
-	im.cacheMutex.RLock()
-	defer im.cacheMutex.RUnlock()
-	item, exists := im.cache[key]
-	return item, exists
+	im.cacheMutex.Lock()
+	defer im.cacheMutex.Unlock()
+	return im.get(key)
+}
+
+// get is Get for callers already holding cacheMutex.
+func (im *ImageManager) get(key string) (any, bool) {
+	e, exists := im.cache[key]
+	if !exists {
+		return nil, false
+	}
+	im.cacheOrder.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
 }
 
-// Set adds an image (static or animated) to the cache.
+// Set adds an image (static or animated) to the cache. Once the cache holds more
+// than cacheSize images, the least recently used ones are evicted and disposed by
+// disposeEvicted when no object shows them anymore. An image replaced under the
+// same key is disposed the same way.
 func (im *ImageManager) Set(key string, value any) {
 	im.cacheMutex.Lock()
 	defer im.cacheMutex.Unlock()
-	im.cache[key] = value
+	if e, exists := im.cache[key]; exists {
+		entry := e.Value.(*cacheEntry)
+		if entry.value != value {
+			im.evicted = append(im.evicted, entry.value) // Replaced, so disposed like an evicted image
+		}
+		entry.value = value
+		im.cacheOrder.MoveToFront(e)
+		return
+	}
+	im.cache[key] = im.cacheOrder.PushFront(&cacheEntry{key, value})
+	for im.cacheSize > 0 && im.cacheOrder.Len() > im.cacheSize {
+		oldest := im.cacheOrder.Remove(im.cacheOrder.Back()).(*cacheEntry)
+		delete(im.cache, oldest.key)
+		im.evicted = append(im.evicted, oldest.value)
+	}
+	metrics.cacheSize.Set(len(im.cache))
+}
+
+// claimLoad shows the cached image for key on obj if there is one, returning nil.
+// Otherwise it returns the load of the image, and whether the caller must load
+// it: leader is false if another object is loading it already, in which case the
+// caller waits for load.done.
+func (im *ImageManager) claimLoad(obj *ReactionObject, key string) (load *pendingLoad, leader bool) {
+	im.cacheMutex.Lock()
+	defer im.cacheMutex.Unlock()
+	if cachedItem, exists := im.get(key); exists {
+		switch item := cachedItem.(type) {
+		case *ebiten.Image:
+			obj.image = item
+		case *AnimatedImage:
+			obj.animatedImage = item
+		default:
+			im.useFallback(obj, strings.Trim(key, ":"))
+		}
+		return nil, false
+	}
+	if load, exists := im.loads[key]; exists {
+		return load, false
+	}
+	load = &pendingLoad{done: make(chan struct{})}
+	im.loads[key] = load
+	return load, true
+}
+
+// finishLoad ends a load started by claimLoad, waking the objects waiting for it.
+func (im *ImageManager) finishLoad(key string, load *pendingLoad) {
+	im.cacheMutex.Lock()
+	defer im.cacheMutex.Unlock()
+	delete(im.loads, key)
+	close(load.done)
+}
+
+// disposeEvicted frees the GPU memory of evicted images that none of objects
+// shows anymore. Images still in use are kept until a later call. Drawing a
+// disposed image panics, so this must run on the game loop, between draws.
+func (im *ImageManager) disposeEvicted(objects []*ReactionObject) {
+	im.cacheMutex.Lock()
+	defer im.cacheMutex.Unlock()
+	if len(im.evicted) == 0 {
+		return
+	}
+	inUse := make(map[any]bool, len(objects))
+	for _, o := range objects {
+		if o.image != nil {
+			inUse[o.image] = true
+		}
+		if o.animatedImage != nil {
+			inUse[o.animatedImage] = true
+		}
+	}
+	kept := im.evicted[:0]
+	for _, item := range im.evicted {
+		if inUse[item] {
+			kept = append(kept, item)
+			continue
+		}
+		switch item := item.(type) {
+		case *ebiten.Image:
+			item.Dispose()
+		case *AnimatedImage:
+			for _, frame := range item.Frames {
+				frame.Dispose()
+			}
+		}
+	}
+	clear(im.evicted[len(kept):])
+	im.evicted = kept
 }
 
 // AnimatedImage holds all the pre-rendered frames for an animation.
 type AnimatedImage struct {
 	Frames      []*ebiten.Image
 	FrameDelays []int // Delay in milliseconds
+	Loops       int   // Number of times to play the animation, 0 means forever
 }
 
 // DecodedImage holds the result of decoding, which can be static or animated.
@@ -113,10 +550,21 @@ type DecodedImage struct {
 	Animated *AnimatedImage
 }
 
+// normalizeDelays converts frame delays given in multiples of unit to the
+// milliseconds stored in AnimatedImage, rounding to the nearest millisecond.
+// GIF delays are in 1/100s, APNG delays in seconds and WebP delays in milliseconds.
+func normalizeDelays[T int | float64](delays []T, unit time.Duration) []int {
+	ms := make([]int, len(delays))
+	for i, d := range delays {
+		ms[i] = int(math.Round(float64(d) * float64(unit) / float64(time.Millisecond)))
+	}
+	return ms
+}
+
 // preRenderApngAnimation composites an APNG's frames onto a canvas.
 func preRenderApngAnimation(animation *apng.APNG, canvasWidth, canvasHeight int) *AnimatedImage {
 	var frames []*ebiten.Image
-	var frameDelays []int
+	var frameDelays []float64
 
 	// Prepare canvases for composition.
 	canvas := image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
@@ -149,12 +597,9 @@ func preRenderApngAnimation(animation *apng.APNG, canvasWidth, canvasHeight int)
 		// Create a true copy of the canvas for this animation frame.
 		frameCopy := image.NewRGBA(canvas.Bounds())
 		draw.Draw(frameCopy, frameCopy.Bounds(), canvas, image.Point{}, draw.Src)
-		frames = append(frames, ebiten.NewImageFromImage(frameCopy))
+		frames = append(frames, newImageFromImage(frameCopy))
 
-		// Convert frame delay and append.
-		delaySeconds := frame.GetDelay() // Returns delay in seconds as float64
-		delayInMilliseconds := int(math.Round(delaySeconds * 1000))
-		frameDelays = append(frameDelays, delayInMilliseconds)
+		frameDelays = append(frameDelays, frame.GetDelay()) // Returns delay in seconds as float64
 
 		// Handle disposal method to prepare canvas for the *next* frame.
 		switch frame.DisposeOp {
@@ -169,7 +614,8 @@ func preRenderApngAnimation(animation *apng.APNG, canvasWidth, canvasHeight int)
 
 	return &AnimatedImage{
 		Frames:      frames,
-		FrameDelays: frameDelays,
+		FrameDelays: normalizeDelays(frameDelays, time.Second),
+		Loops:       int(animation.LoopCount),
 	}
 }
 
@@ -177,10 +623,10 @@ func preRenderApngAnimation(animation *apng.APNG, canvasWidth, canvasHeight int)
 func preRenderWebpAnimation(animation *webp.WEBP) *AnimatedImage {
 	var frames []*ebiten.Image
 	for _, frame := range animation.Image {
-		frames = append(frames, ebiten.NewImageFromImage(frame))
+		frames = append(frames, newImageFromImage(frame))
 	}
 
-	return &AnimatedImage{Frames: frames, FrameDelays: animation.Delay}
+	return &AnimatedImage{Frames: frames, FrameDelays: normalizeDelays(animation.Delay, time.Millisecond)}
 }
 
 // preRenderGifAnimation composites a GIF's frames onto a canvas.
@@ -188,20 +634,34 @@ func preRenderGifAnimation(g *gif.GIF) *AnimatedImage {
 	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
 	var frames []*ebiten.Image
 	for i, srcImg := range g.Image {
-		draw.Draw(canvas, srcImg.Bounds(), srcImg, srcImg.Bounds().Min, draw.Over)
+		// Optimized GIFs store sub-frames at an offset; dispose only the area they cover.
+		bounds := srcImg.Bounds().Intersect(canvas.Bounds())
+		var previous *image.RGBA
+		if g.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, bounds.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, bounds, srcImg, bounds.Min, draw.Over)
 		frameCopy := image.NewRGBA(canvas.Bounds())
 		draw.Draw(frameCopy, frameCopy.Bounds(), canvas, image.Point{}, draw.Src)
-		frames = append(frames, ebiten.NewImageFromImage(frameCopy))
-		if g.Disposal[i] == gif.DisposalBackground {
-			draw.Draw(canvas, srcImg.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		frames = append(frames, newImageFromImage(frameCopy))
+
+		switch g.Disposal[i] {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, bounds, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			draw.Draw(canvas, bounds, previous, bounds.Min, draw.Src)
 		}
 	}
-	// Convert delay from 1/100s of a second to milliseconds.
-	delaysInMs := make([]int, len(g.Delay))
-	for i, d := range g.Delay {
-		delaysInMs[i] = d * 10
+	// GIF's LoopCount is the number of restarts, with -1 meaning play once.
+	loops := 0
+	if g.LoopCount < 0 {
+		loops = 1
+	} else if g.LoopCount > 0 {
+		loops = g.LoopCount + 1
 	}
-	return &AnimatedImage{Frames: frames, FrameDelays: delaysInMs}
+	return &AnimatedImage{Frames: frames, FrameDelays: normalizeDelays(g.Delay, 10*time.Millisecond), Loops: loops}
 }
 
 // stripTRNSFromRGBA reads a PNG stream and removes the tRNS chunk if the color
@@ -267,22 +727,146 @@ func stripTRNSFromRGBA(r io.Reader) (io.Reader, error) {
 }
 
 // fetchAndDecodeImage downloads and decodes an image. It distinguishes between static
-// and animated images to process them more efficiently.
-func fetchAndDecodeImage(url string) (*DecodedImage, error) {
-	resp, err := http.Get(url)
+// and animated images to process them more efficiently. If token is not empty, it is
+// sent as a bearer token for instances that require authentication for media.
+// The download is aborted, and decoding skipped, once ctx is cancelled.
+// If disk is not nil, the image is taken from it when stored there, and stored
+// there once it has been fetched and decoded. Stored images that no longer decode
+// are removed and fetched again.
+func fetchAndDecodeImage(ctx context.Context, disk *diskCache, url, token string) (*DecodedImage, error) {
+	if disk != nil {
+		if data, ok := disk.load(url); ok {
+			decoded, err := decodeImageInSlot(ctx, data)
+			if err == nil || ctx.Err() != nil {
+				return decoded, err
+			}
+			log.Printf("Cached image for %s cannot be decoded, fetching it again: %v", url, err)
+			disk.remove(url)
+		}
+	}
+
+	data, err := fetchImageRetrying(ctx, url, token)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeImageInSlot(ctx, data)
+	if err == nil && disk != nil {
+		disk.store(url, data)
+	}
+	return decoded, err
+}
+
+// decodeImageInSlot decodes data with decodeImage once a decode slot is free.
+func decodeImageInSlot(ctx context.Context, data []byte) (*DecodedImage, error) {
+	select {
+	case decodeSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-decodeSlots }()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return decodeImage(data)
+}
+
+// fetchImageRetrying downloads the image at url like fetchImage, retrying after
+// each of retryDelays while the server responds with a 5xx status or the
+// connection fails. A fetch slot is only held while fetching, not while waiting.
+func fetchImageRetrying(ctx context.Context, url, token string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		select {
+		case fetchSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		data, err := fetchImage(ctx, url, token)
+		<-fetchSlots
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return data, err
+		}
+		if attempt == len(retryDelays) {
+			return nil, fmt.Errorf("%w (gave up after %d attempts)", err, attempt+1)
+		}
+		select {
+		case <-time.After(retryDelays[attempt]):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether a fetch that failed with err may succeed when tried
+// again. Timeouts are not retried, since they already took fetchTimeout.
+func retryable(err error) bool {
+	var opErr *net.OpError // Failing to connect, or the connection breaking
+	return errors.Is(err, errServer) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &opErr)
+}
+
+// fetchImage downloads the image at url, sending token as a bearer token if set.
+func fetchImage(ctx context.Context, url, token string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("User-Agent", userAgent)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, timeoutError(err)
+	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("bad status: %s: %w", resp.Status, errNotFound)
+	}
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("bad status: %s: %w", resp.Status, errExpired)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("bad status: %s: %w", resp.Status, errServer)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("bad status: %s", resp.Status)
 	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, timeoutError(err)
 	}
+	return data, nil
+}
+
+// timeoutError wraps err with errTimeout if it is the client timing out, so the
+// log says so plainly instead of quoting the transport's error.
+func timeoutError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("took longer than %v: %w", httpClient.Timeout, errTimeout)
+	}
+	return err
+}
+
+// decodeImage decodes a static or animated image and uploads its frames to the GPU.
+func decodeImage(data []byte) (*DecodedImage, error) {
+	defer observeDecode(time.Now())
 	contentType := http.DetectContentType(data)
 
+	// Check the declared size before decoding, since animations allocate
+	// full-size canvases up front.
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && (cfg.Width > maxCanvasSize || cfg.Height > maxCanvasSize) {
+		return nil, fmt.Errorf("image size %dx%d exceeds %d", cfg.Width, cfg.Height, maxCanvasSize)
+	}
+
+	if staticOnly || staticFormats[animatedFormat(contentType)] {
+		// image.Decode only decodes the first frame (or the default image of an
+		// APNG), so no animation frames are built. Formats it doesn't know, such
+		// as ICO, continue below and are static anyway.
+		if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+			return &DecodedImage{Static: newImageFromImage(img)}, nil
+		}
+	}
+
 	if strings.Contains(contentType, "gif") {
 		// First, decode the full GIF to check the frame count.
 		g, err := gif.DecodeAll(bytes.NewReader(data))
@@ -296,7 +880,7 @@ func fetchAndDecodeImage(url string) (*DecodedImage, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &DecodedImage{Static: ebiten.NewImageFromImage(img)}, nil
+			return &DecodedImage{Static: newImageFromImage(img)}, nil
 		}
 
 		// Otherwise, process it as an animation by pre-rendering it.
@@ -332,7 +916,7 @@ func fetchAndDecodeImage(url string) (*DecodedImage, error) {
 			if staticErr != nil {
 				return nil, err // Return original apng error
 			}
-			return &DecodedImage{Static: ebiten.NewImageFromImage(img)}, nil
+			return &DecodedImage{Static: newImageFromImage(img)}, nil
 		}
 
 		// Check number of actual animation frames (non-default).
@@ -349,7 +933,7 @@ func fetchAndDecodeImage(url string) (*DecodedImage, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &DecodedImage{Static: ebiten.NewImageFromImage(img)}, nil
+			return &DecodedImage{Static: newImageFromImage(img)}, nil
 		}
 
 		// It's an animation, so pre-render the frames.
@@ -363,7 +947,7 @@ func fetchAndDecodeImage(url string) (*DecodedImage, error) {
 			if staticErr != nil {
 				return nil, err // Return original animation error
 			}
-			return &DecodedImage{Static: ebiten.NewImageFromImage(img)}, nil
+			return &DecodedImage{Static: newImageFromImage(img)}, nil
 		}
 
 		if len(animation.Image) <= 1 {
@@ -371,19 +955,54 @@ func fetchAndDecodeImage(url string) (*DecodedImage, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &DecodedImage{Static: ebiten.NewImageFromImage(img)}, nil
+			return &DecodedImage{Static: newImageFromImage(img)}, nil
 		}
 
 		anim := preRenderWebpAnimation(animation)
 		return &DecodedImage{Animated: anim}, nil
+	} else if strings.Contains(contentType, "icon") {
+		img, err := decodeICO(data)
+		if err != nil {
+			return nil, err
+		}
+		return &DecodedImage{Static: newImageFromImage(img)}, nil
 	} else {
 		// For all other image types (jpeg, etc.)
 		img, _, err := image.Decode(bytes.NewReader(data))
 		if err != nil {
 			return nil, err
 		}
-		return &DecodedImage{Static: ebiten.NewImageFromImage(img)}, nil
+		return &DecodedImage{Static: newImageFromImage(img)}, nil
+	}
+}
+
+// animatedFormat returns the name of the animated format that an image with the
+// detected contentType may use: "gif", "apng" or "webp", or "" for other types.
+func animatedFormat(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "gif"):
+		return "gif"
+	case strings.Contains(contentType, "png"):
+		return "apng"
+	case strings.Contains(contentType, "webp"):
+		return "webp"
+	}
+	return ""
+}
+
+// newImageFromImage uploads img to the GPU, downscaling it to fit within
+// maxImageSize while keeping its aspect ratio.
+func newImageFromImage(img image.Image) *ebiten.Image {
+	b := img.Bounds()
+	longest := max(b.Dx(), b.Dy())
+	if maxImageSize <= 0 || longest <= maxImageSize {
+		return uploadImage(img)
 	}
+	w := max(1, b.Dx()*maxImageSize/longest)
+	h := max(1, b.Dy()*maxImageSize/longest)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return uploadImage(dst)
 }
 
 func emojiToTwemojiURL(emoji string) string {
@@ -393,5 +1012,23 @@ func emojiToTwemojiURL(emoji string) string {
 			codes = append(codes, fmt.Sprintf("%x", r))
 		}
 	}
-	return fmt.Sprintf("https://cdn.jsdelivr.net/gh/twitter/twemoji@latest/assets/72x72/%s.png", strings.Join(codes, "-"))
+	return fmt.Sprintf(twemojiURL, strings.Join(codes, "-"))
+}
+
+// baseEmoji strips skin tone modifiers and variation selectors from an emoji and
+// keeps only the first element of a ZWJ sequence, leaving a glyph Twemoji is
+// most likely to host.
+func baseEmoji(emoji string) string {
+	var b strings.Builder
+	for _, r := range emoji {
+		switch {
+		case r == 0x200d: // Zero width joiner
+			return b.String()
+		case r >= 0x1f3fb && r <= 0x1f3ff: // Skin tone modifiers
+		case r == 0xfe0e || r == 0xfe0f: // Variation selectors
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
 }
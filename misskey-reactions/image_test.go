@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// failFetch is a fetcher that always fails with err.
+func failFetch(err error) func(ctx context.Context, url, token string) (*DecodedImage, error) {
+	return func(ctx context.Context, url, token string) (*DecodedImage, error) {
+		return nil, err
+	}
+}
+
+// TestLoadFailuresShowFallback drives every way loading an image can fail and
+// checks the object is never left with nothing to draw.
+func TestLoadFailuresShowFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      *stubMisskey
+		fetch    func(ctx context.Context, url, token string) (*DecodedImage, error)
+		reaction ReactionInfo
+	}{
+		{
+			name:     "API query error",
+			api:      &stubMisskey{queryErr: errors.New("unreachable")},
+			fetch:    failFetch(errors.New("must not be fetched")),
+			reaction: ReactionInfo{Name: ":custom:"},
+		},
+		{
+			name:     "fetch error",
+			api:      &stubMisskey{},
+			fetch:    failFetch(errors.New("connection refused")),
+			reaction: ReactionInfo{Name: ":custom:", URL: "https://example.com/custom.png"},
+		},
+		{
+			name:     "Twemoji not found",
+			api:      &stubMisskey{},
+			fetch:    failFetch(errNotFound),
+			reaction: ReactionInfo{Name: "👍🏽"},
+		},
+		{
+			name: "no frames",
+			api:  &stubMisskey{},
+			fetch: func(ctx context.Context, url, token string) (*DecodedImage, error) {
+				return &DecodedImage{Animated: &AnimatedImage{}}, nil
+			},
+			reaction: ReactionInfo{Name: ":custom:", URL: "https://example.com/custom.gif"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := NewImageManager(tt.api)
+			im.fetch = tt.fetch
+			obj := &ReactionObject{reactionName: tt.reaction.Name}
+			im.LoadImageForObject(context.Background(), obj, tt.reaction)
+			if obj.fallbackText == "" && obj.image == nil && obj.animatedImage == nil {
+				t.Error("object has neither fallback text nor an image")
+			}
+			if obj.animatedImage != nil && len(obj.animatedImage.Frames) == 0 {
+				t.Error("object shows an animation without frames")
+			}
+		})
+	}
+}
+
+// Once the load queue is full, objects show their fallback right away.
+func TestEnqueueFullQueueShowsFallback(t *testing.T) {
+	im := NewImageManager(&stubMisskey{}) // No workers, so the queue only fills up
+	for range cap(im.queue) {
+		im.Enqueue(context.Background(), &ReactionObject{}, ReactionInfo{Name: ":queued:"})
+	}
+	obj := &ReactionObject{}
+	im.Enqueue(context.Background(), obj, ReactionInfo{Name: ":overflow:"})
+	if obj.fallbackText != "overflow" {
+		t.Errorf("fallbackText = %q, want %q", obj.fallbackText, "overflow")
+	}
+}
+
+// blockingFetch returns a fetcher that counts its calls and blocks until
+// release is closed, then returns decoded or err.
+func blockingFetch(calls *atomic.Int32, release <-chan struct{}, decoded *DecodedImage, err error) func(ctx context.Context, url, token string) (*DecodedImage, error) {
+	return func(ctx context.Context, url, token string) (*DecodedImage, error) {
+		calls.Add(1)
+		<-release
+		return decoded, err
+	}
+}
+
+// spawnIdentical spawns n objects for the same reaction in a game whose image
+// manager runs the given number of load workers with fetch. Once every worker
+// but the fetching one waits for that fetch, it releases the fetch and returns
+// the objects after all their loads have been handled.
+func spawnIdentical(t *testing.T, n, workers int, fetch func(ctx context.Context, url, token string) (*DecodedImage, error), release chan struct{}) []*ReactionObject {
+	t.Helper()
+	setScale(t, 1)
+	im := NewImageManager(&stubMisskey{})
+	im.fetch = fetch
+	waiting := make(chan struct{}, n)
+	im.waiting = func(*ReactionObject) { waiting <- struct{}{} }
+	var loaded sync.WaitGroup
+	loaded.Add(n)
+	im.loaded = func(*ReactionObject) { loaded.Done() }
+	im.StartWorkers(workers)
+	g := NewGame(nil, im)
+	g.Layout(800, 600)
+	for range n {
+		g.spawnReaction(ReactionInfo{Name: ":same:", URL: "https://example.com/same.png"}, g.bounds())
+	}
+
+	timeout := time.After(5 * time.Second)
+	for range min(n, workers) - 1 {
+		select {
+		case <-waiting:
+		case <-timeout:
+			t.Fatal("objects are not waiting for the fetch")
+		}
+	}
+	close(release)
+	done := make(chan struct{})
+	go func() {
+		loaded.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-timeout:
+		t.Fatal("objects are still loading")
+	}
+	return g.objects
+}
+
+func TestIdenticalReactionsFetchOnce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	anim := &AnimatedImage{Frames: []*ebiten.Image{ebiten.NewImage(1, 1)}, FrameDelays: []int{100}}
+	objects := spawnIdentical(t, 20, 8, blockingFetch(&calls, release, &DecodedImage{Animated: anim}, nil), release)
+	if got := calls.Load(); got != 1 {
+		t.Errorf("fetched %d times, want 1", got)
+	}
+	for i, o := range objects {
+		if o.animatedImage != anim {
+			t.Errorf("object %d does not show the fetched image", i)
+		}
+	}
+}
+
+// A failed load is reported to every object waiting for it, not only to the
+// object that fetched it.
+func TestIdenticalReactionsShareFailure(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	const workers = 8 // One object per worker, so all of them are waiting when the fetch fails
+	objects := spawnIdentical(t, workers, workers, blockingFetch(&calls, release, nil, errors.New("connection refused")), release)
+	if got := calls.Load(); got != 1 {
+		t.Errorf("fetched %d times, want 1", got)
+	}
+	for i, o := range objects {
+		if o.fallbackText != "same" {
+			t.Errorf("object %d: fallbackText = %q, want %q", i, o.fallbackText, "same")
+		}
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	im := NewImageManager(&stubMisskey{})
+	im.cacheSize = 3
+	images := map[string]*ebiten.Image{}
+	for _, key := range []string{":a:", ":b:", ":c:", ":d:"} {
+		images[key] = ebiten.NewImage(1, 1)
+	}
+	im.Set(":a:", images[":a:"])
+	im.Set(":b:", images[":b:"])
+	im.Set(":c:", images[":c:"])
+	im.Get(":a:") // Now :b: is the least recently used
+	im.Set(":d:", images[":d:"])
+
+	if _, ok := im.Get(":b:"); ok {
+		t.Error(":b: is still cached, want it evicted")
+	}
+	for _, key := range []string{":a:", ":c:", ":d:"} {
+		if _, ok := im.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if len(im.evicted) != 1 || im.evicted[0] != any(images[":b:"]) {
+		t.Errorf("evicted = %v, want only the image of :b:", im.evicted)
+	}
+
+	// Replacing an image queues the old one for disposal too.
+	im.Set(":a:", ebiten.NewImage(1, 1))
+	if len(im.evicted) != 2 || im.evicted[1] != any(images[":a:"]) {
+		t.Errorf("the replaced image of :a: was not queued for disposal")
+	}
+
+	// Evicted images still shown by an object are kept until it is gone.
+	obj := &ReactionObject{image: images[":b:"]}
+	im.disposeEvicted([]*ReactionObject{obj})
+	if len(im.evicted) != 1 || im.evicted[0] != any(images[":b:"]) {
+		t.Errorf("evicted = %v after disposing, want only the image in use", im.evicted)
+	}
+	im.disposeEvicted(nil)
+	if len(im.evicted) != 0 {
+		t.Errorf("%d evicted images left once none is in use", len(im.evicted))
+	}
+}
+
+func TestFetchImageTimesOut(t *testing.T) {
+	userAgents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	old := httpClient.Timeout
+	httpClient.Timeout = 50 * time.Millisecond
+	t.Cleanup(func() { httpClient.Timeout = old })
+
+	_, err := fetchImage(context.Background(), srv.URL, "")
+	if !errors.Is(err, errTimeout) {
+		t.Errorf("err = %v, want errTimeout", err)
+	}
+	if ua := <-userAgents; ua != userAgent {
+		t.Errorf("User-Agent = %q, want %q", ua, userAgent)
+	}
+}
+
+func TestFetchImageRetries(t *testing.T) {
+	old := retryDelays
+	retryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { retryDelays = old })
+
+	tests := []struct {
+		name         string
+		statuses     []int // Responses in order, the last one repeating
+		wantRequests int32
+		wantErr      bool
+	}{
+		{"recovers after two 5xx", []int{500, 503, 200}, 3, false},
+		{"gives up after three 5xx", []int{502}, 3, true},
+		{"404 is not retried", []int{404, 200}, 1, true},
+		{"403 is not retried", []int{403, 200}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				status := tt.statuses[min(n, len(tt.statuses))-1]
+				w.WriteHeader(status)
+				w.Write([]byte("image"))
+			}))
+			defer srv.Close()
+
+			data, err := fetchImageRetrying(context.Background(), srv.URL, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(data) != "image" {
+				t.Errorf("data = %q, want %q", data, "image")
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("%d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestNormalizeDelays(t *testing.T) {
+	tests := []struct {
+		name      string
+		got, want []int
+	}{
+		{"GIF centiseconds", normalizeDelays([]int{1, 10, 150}, 10*time.Millisecond), []int{10, 100, 1500}},
+		{"APNG seconds", normalizeDelays([]float64{0.1, 1.0 / 3, 0.0166}, time.Second), []int{100, 333, 17}},
+		{"WebP milliseconds", normalizeDelays([]int{16, 100}, time.Millisecond), []int{16, 100}},
+		// Zero delays stay 0, so the game can substitute -zero-frame-delay.
+		{"GIF zero delays", normalizeDelays([]int{0, 0}, 10*time.Millisecond), []int{0, 0}},
+		{"APNG zero delays", normalizeDelays([]float64{0}, time.Second), []int{0}},
+		{"no frames", normalizeDelays([]int(nil), time.Millisecond), []int{}},
+	}
+	for _, tt := range tests {
+		if !slices.Equal(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// pngHeader returns the start of a PNG whose IHDR declares a w x h RGBA
+// image, without any pixel data.
+func pngHeader(w, h uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0) // 8-bit RGBA, no interlacing
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestDecodeImageRejectsOversizedCanvas(t *testing.T) {
+	old := uploadImage
+	uploadImage = func(img image.Image) *ebiten.Image {
+		t.Fatal("an oversized image was decoded and uploaded")
+		return nil
+	}
+	t.Cleanup(func() { uploadImage = old })
+
+	_, err := decodeImage(pngHeader(uint32(maxCanvasSize)+1, 1))
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("err = %v, want the declared size rejected", err)
+	}
+}
+
+// captureUploads replaces uploadImage for the duration of the test and
+// returns the images it was given.
+func captureUploads(t *testing.T) *[]image.Image {
+	t.Helper()
+	var uploaded []image.Image
+	old := uploadImage
+	uploadImage = func(img image.Image) *ebiten.Image {
+		uploaded = append(uploaded, img)
+		return ebiten.NewImage(1, 1)
+	}
+	t.Cleanup(func() { uploadImage = old })
+	return &uploaded
+}
+
+func TestPreRenderGifAnimationDisposesSubFrames(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	pal := color.Palette{color.Transparent, red, blue, green, white}
+	frame := func(r image.Rectangle, c color.Color) *image.Paletted {
+		img := image.NewPaletted(r, pal)
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+		return img
+	}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 4, 4), red),
+			frame(image.Rect(1, 1, 3, 3), blue),
+			frame(image.Rect(0, 0, 1, 1), green),
+			frame(image.Rect(3, 3, 4, 4), white),
+		},
+		Delay:    []int{10, 10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 4},
+	}
+	uploaded := captureUploads(t)
+
+	preRenderGifAnimation(g)
+
+	// Each frame is checked at the top-left corner, inside the background
+	// disposed sub-frame and at the bottom-right corner.
+	want := [][3]color.Color{
+		{red, red, red},
+		{red, blue, red},
+		{green, color.RGBA{}, red},
+		{red, color.RGBA{}, white},
+	}
+	if len(*uploaded) != len(want) {
+		t.Fatalf("uploaded %d frames, want %d", len(*uploaded), len(want))
+	}
+	points := []image.Point{{0, 0}, {1, 1}, {3, 3}}
+	for i, img := range *uploaded {
+		if img.Bounds() != image.Rect(0, 0, 4, 4) {
+			t.Errorf("frame %d bounds = %v, want the full canvas", i, img.Bounds())
+		}
+		for j, p := range points {
+			got := color.RGBAModel.Convert(img.At(p.X, p.Y))
+			if got != want[i][j] {
+				t.Errorf("frame %d at %v = %v, want %v", i, p, got, want[i][j])
+			}
+		}
+	}
+}
+
+// A custom emoji of the instance wins over a standard emoji with the same shortcode.
+func TestCustomEmojiShadowsShortcode(t *testing.T) {
+	tests := []struct {
+		name    string
+		custom  map[string]bool
+		wantURL string
+	}{
+		{"not defined by the instance", nil, emojiToTwemojiURL(shortcodeEmojis["smile"])},
+		{"defined by the instance", map[string]bool{"smile": true}, "https://example.com/emoji/smile.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := NewImageManager(&stubMisskey{custom: tt.custom})
+			var fetched string
+			im.fetch = func(ctx context.Context, url, token string) (*DecodedImage, error) {
+				fetched = url
+				return nil, errors.New("not needed")
+			}
+			im.LoadImageForObject(context.Background(), &ReactionObject{}, ReactionInfo{Name: ":smile:"})
+			if fetched != tt.wantURL {
+				t.Errorf("fetched %q, want %q", fetched, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestTwemojiTemplate(t *testing.T) {
+	tests := []struct {
+		url, size string
+		want      string
+		wantErr   bool
+	}{
+		{url: defaultTwemojiURL, size: "72", want: "https://cdn.jsdelivr.net/gh/twitter/twemoji@latest/assets/72x72/%s.png"},
+		{url: "https://mirror.example/{size}/%s.png", size: "144", want: "https://mirror.example/144/%s.png"},
+		{url: "https://mirror.example/%s.png", size: "144", want: "https://mirror.example/%s.png"},
+		{url: defaultTwemojiURL, size: "svg", wantErr: true},
+		{url: defaultTwemojiURL, size: "0", wantErr: true},
+		{url: "https://mirror.example/emoji.png", size: "72", wantErr: true},
+		{url: "https://mirror.example/%s/%d.png", size: "72", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := twemojiTemplate(tt.url, tt.size)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("twemojiTemplate(%q, %q) = %q, want an error", tt.url, tt.size, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("twemojiTemplate(%q, %q) = %q, %v, want %q", tt.url, tt.size, got, err, tt.want)
+		}
+	}
+	if got, _ := twemojiTemplate(defaultTwemojiURL, "72"); got != twemojiURL {
+		t.Errorf("default template %q differs from the built-in twemojiURL %q", got, twemojiURL)
+	}
+}
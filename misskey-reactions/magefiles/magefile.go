@@ -91,25 +91,39 @@ func Credits() {
 	defer f.Close()
 }
 
+// Cross builds release archives with goxz. Set DRY_RUN=1 to print the command
+// and environment instead of running them.
 func Cross(goos, arch string) {
+	dryRun := os.Getenv("DRY_RUN") != ""
 	_, err := exec.LookPath("goxz")
-	if err != nil {
+	if err != nil && !dryRun {
 		fmt.Println("installing goxz")
 		sh.Run("go", "install", "github.com/Songmu/goxz/cmd/goxz@latest")
 	}
 	if runtime.GOOS == "windows" {
 		BUILD_LDFLAGS += " -H=windowsgui"
 	}
+	env := map[string]string{}
 	// https://github.com/syncthing/syncthing/blob/7189a3ebffb7b7bd59bce510753bc6d97988eacd/.github/workflows/build-syncthing.yaml
 	if goos == "linux" && arch == "arm64" {
-		os.Setenv("GOOS", "linux")
-		os.Setenv("GOARCH", "arm64")
-		os.Setenv("CC", "zig cc -target aarch64-linux-gnu")
-		os.Setenv("CGO_ENABLED", "1")
-		os.Setenv("CGO_CFLAGS", "-isystem /usr/include")
-		os.Setenv("CGO_LDFLAGS", "-L/usr/lib/aarch64-linux-gnu")
+		env["GOOS"] = "linux"
+		env["GOARCH"] = "arm64"
+		env["CC"] = "zig cc -target aarch64-linux-gnu"
+		env["CGO_ENABLED"] = "1"
+		env["CGO_CFLAGS"] = "-isystem /usr/include"
+		env["CGO_LDFLAGS"] = "-L/usr/lib/aarch64-linux-gnu"
+	}
+	args := []string{"-n", BIN, "-o", BIN, "-os", goos, "-arch", arch, "-pv=v" + VERSION, "-build-ldflags", BUILD_LDFLAGS, BUILD_TARGET}
+	if dryRun {
+		for _, k := range []string{"GOOS", "GOARCH", "CC", "CGO_ENABLED", "CGO_CFLAGS", "CGO_LDFLAGS"} {
+			if v, ok := env[k]; ok {
+				fmt.Printf("%s=%q\n", k, v)
+			}
+		}
+		fmt.Printf("goxz %q\n", args)
+		return
 	}
-	sh.Run("goxz", "-n", BIN, "-o", BIN, "-os", goos, "-arch", arch, "-pv=v"+VERSION, "-build-ldflags", BUILD_LDFLAGS, BUILD_TARGET)
+	sh.RunWith(env, "goxz", args...)
 }
 
 func Bump() {
@@ -2,8 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
+	"fmt"
+	"image"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -15,88 +22,441 @@ const version = "0.0.3"
 
 var revision = "HEAD"
 
+// mockData is the reaction data sent in test mode.
+var mockData = []ReactionInfo{
+	{Name: "👍"},
+	// {Name: ":ebiten:", URL: "https://ebitengine.org/images/logo.png"},                                                               // Valid custom emoji
+	{Name: ":misskey:", URL: "https://proxy.misskeyusercontent.jp/image/media.misskeyusercontent.jp%2Femoji%2Fmisskey.png?emoji=1"}, // Valid custom emoji
+	{Name: "Go"}, // Standard text, will become a Twemoji
+	{Name: ":error:", URL: "https://example.com/nonexistent-image.png"}, // Invalid custom emoji to test fallback
+	{Name: "❤️"},
+	{Name: ":ai_nomming:", URL: "https://proxy.misskeyusercontent.jp/image/media.misskeyusercontent.jp%2Fmisskey%2Ff6294900-f678-43cc-bc36-3ee5deeca4c2.gif?emoji=1"},
+	{Name: ":meowsurprised:", URL: "https://proxy.misskeyusercontent.jp/image/media.misskeyusercontent.jp%2Femoji%2FmeowSurprised.png?emoji=1"},
+	{Name: ":bug:", URL: "https://media.misskeyusercontent.jp/misskey/7ac83d54-033b-4eee-8703-9cba7052992c.gif"},
+	{Name: ":syuilo_yay:", URL: "https://media.misskeyusercontent.jp/io/939d3f91-86dc-491f-a6f2-dcfee43974b4.apng"}, // invalid format: chunk out of order
+	{Name: ":ai_akan:", URL: "https://media.misskeyusercontent.jp/misskey/ff4ff841-1b94-412a-9708-76781ac5a29f.png"},
+	{Name: ":murakamisan_spin:", URL: "https://media.misskeyusercontent.jp/io/45a238ca-6319-4781-8bbe-b6b4c6fcca73.gif"},
+	{Name: ":blobdance2:", URL: "https://media.misskeyusercontent.jp/io/51f11775-f498-4a61-9220-08427735068f.gif"},
+	{Name: ":resonyance:", URL: "https://media.misskeyusercontent.jp/emoji/resonyance.webp"},
+}
+
 // runTestMode sends mock reaction data to the channel for testing purposes.
 func runTestMode(reactionChan chan<- ReactionInfo) {
 	log.Println("--- RUNNING IN TEST MODE ---")
-	mockData := []ReactionInfo{
-		{Name: "👍"},
-		// {Name: ":ebiten:", URL: "https://ebitengine.org/images/logo.png"},                                                               // Valid custom emoji
-		{Name: ":misskey:", URL: "https://proxy.misskeyusercontent.jp/image/media.misskeyusercontent.jp%2Femoji%2Fmisskey.png?emoji=1"}, // Valid custom emoji
-		{Name: "Go"}, // Standard text, will become a Twemoji
-		{Name: ":error:", URL: "https://example.com/nonexistent-image.png"}, // Invalid custom emoji to test fallback
-		{Name: "❤️"},
-		{Name: ":ai_nomming:", URL: "https://proxy.misskeyusercontent.jp/image/media.misskeyusercontent.jp%2Fmisskey%2Ff6294900-f678-43cc-bc36-3ee5deeca4c2.gif?emoji=1"},
-		{Name: ":meowsurprised:", URL: "https://proxy.misskeyusercontent.jp/image/media.misskeyusercontent.jp%2Femoji%2FmeowSurprised.png?emoji=1"},
-		{Name: ":bug:", URL: "https://media.misskeyusercontent.jp/misskey/7ac83d54-033b-4eee-8703-9cba7052992c.gif"},
-		{Name: ":syuilo_yay:", URL: "https://media.misskeyusercontent.jp/io/939d3f91-86dc-491f-a6f2-dcfee43974b4.apng"}, // invalid format: chunk out of order
-		{Name: ":ai_akan:", URL: "https://media.misskeyusercontent.jp/misskey/ff4ff841-1b94-412a-9708-76781ac5a29f.png"},
-		{Name: ":murakamisan_spin:", URL: "https://media.misskeyusercontent.jp/io/45a238ca-6319-4781-8bbe-b6b4c6fcca73.gif"},
-		{Name: ":blobdance2:", URL: "https://media.misskeyusercontent.jp/io/51f11775-f498-4a61-9220-08427735068f.gif"},
-		{Name: ":resonyance:", URL: "https://media.misskeyusercontent.jp/emoji/resonyance.webp"},
-	}
 
 	// Loop forever, sending mock data every 2 seconds
 	for {
 		for _, reaction := range mockData {
 			log.Printf("[TEST MODE] Spawning reaction: %s", reaction.Name)
+			reaction.ReceivedAt = time.Now()
 			reactionChan <- reaction
 			time.Sleep(2 * time.Second)
 		}
 	}
 }
 
-func init() {
-	fontReader := bytes.NewReader(goregular.TTF)
-	s, err := text.NewGoTextFaceSource(fontReader)
+// validateMocks fetches and decodes the image of every mock reaction and prints
+// a table of the results, to keep the mock list and the decoders in check.
+func validateMocks() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tRESULT\tFRAMES\tDELAYS (ms)\tLOOPS\tERROR")
+	for _, reaction := range mockData {
+		url := reaction.URL
+		if url == "" {
+			url = emojiToTwemojiURL(reaction.Name)
+		}
+		decoded, err := fetchAndDecodeImage(context.Background(), nil, url, "")
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "%s\terror\t\t\t\t%v\n", reaction.Name, err)
+		case decoded.Animated != nil:
+			a := decoded.Animated
+			fmt.Fprintf(w, "%s\tanimated\t%d\t%v\t%d\t\n", reaction.Name, len(a.Frames), a.FrameDelays, a.Loops)
+		default:
+			fmt.Fprintf(w, "%s\tstatic\t1\t\t\t\n", reaction.Name)
+		}
+	}
+	w.Flush()
+}
+
+// setup loads the fonts used for fallback text and labels and compiles the shaders.
+// The fonts come from the TTF or OTF file at fontPath, or Go Regular if it is empty.
+func setup(fontPath string) error {
+	fontData := goregular.TTF
+	if fontPath != "" {
+		var err error
+		if fontData, err = os.ReadFile(fontPath); err != nil {
+			return fmt.Errorf("cannot read font: %w", err)
+		}
+	}
+	s, err := text.NewGoTextFaceSource(bytes.NewReader(fontData))
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("cannot load font: %w", err)
 	}
 	fallbackFont = &text.GoTextFace{
 		Source: s,
 		Size:   20,
 	}
+	labelFont = &text.GoTextFace{
+		Source: s,
+		Size:   12,
+	}
+
+	bloomShader, err = ebiten.NewShader(bloomKage)
+	if err != nil {
+		return fmt.Errorf("cannot compile bloom shader: %w", err)
+	}
+	return nil
+}
+
+// setupWindow configures the borderless overlay window on the given -layer,
+// letting clicks pass through to the windows below unless -no-passthrough is
+// set. The layer handling matches setupWindow in floating-circles.
+func setupWindow(layer string, passthrough bool) {
+	switch layer {
+	case "top":
+		ebiten.SetWindowFloating(true)
+	case "normal":
+		ebiten.SetWindowFloating(false)
+	case "background":
+		log.Println("Layer \"background\" is not supported on this platform; using \"normal\" instead.")
+		ebiten.SetWindowFloating(false)
+	default:
+		log.Fatalf("Invalid -layer %q: must be top, normal or background", layer)
+	}
+	ebiten.SetWindowDecorated(false)
+	ebiten.SetWindowMousePassthrough(passthrough)
+	ebiten.SetWindowTitle("Misskey Reactions")
+}
+
+// parseRegion parses a rectangle given as "x,y,w,h". An empty string returns
+// an empty rectangle, meaning the whole window.
+func parseRegion(s string) (image.Rectangle, error) {
+	if s == "" {
+		return image.Rectangle{}, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("want x,y,w,h")
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("width and height must be positive")
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// listMonitors prints the index, name, size and scale factor of every monitor.
+func listMonitors() {
+	for i, m := range ebiten.AppendMonitors(nil) {
+		w, h := m.Size()
+		fmt.Printf("%d: %s (%dx%d, scale %.2f)\n", i, m.Name(), w, h, m.DeviceScaleFactor())
+	}
 }
 
 func main() {
 	testMode := flag.Bool("test", false, "Enable test mode with mock data.")
+	replay := flag.String("replay", "", "Show the objects saved in a snapshot file (press F12 to save one) instead of connecting to Misskey.")
+	source := flag.String("source", "misskey", "Where reactions come from: misskey, or http:ADDR to accept reactions POSTed as JSON on ADDR (e.g. http::8080).")
+	configPath := flag.String("config", "config.json", "Path to the config file, or - to read it from stdin.")
+	randomFlip := flag.Bool("random-flip", false, "Randomly mirror reaction images horizontally.")
+	playOnce := flag.Bool("play-once", false, "Play animations once and then hold a single frame.")
+	holdFrame := flag.String("hold-frame", "last", "Frame to hold after an animation stops: first or last.")
+	trail := flag.Bool("trail", false, "Draw a fading afterimage behind reactions.")
+	lifetimeTicks := flag.Int("lifetime-ticks", 0, "Display every reaction for exactly this many ticks (60 ticks = 1 second). 0 uses a random lifetime.")
+	labels := flag.Bool("labels", false, "Draw the emoji shortcode under every reaction.")
+	fontPath := flag.String("font", "", "TTF or OTF font file for labels and fallback text, instead of Go Regular.")
+	dim := flag.Float64("dim", 0, "Opacity (0.0-1.0) of a dark backdrop drawn behind the reactions.")
+	minVisibleTicks := flag.Int("min-visible-ticks", 0, "Keep every reaction on screen for at least this many ticks, even in a small window.")
+	decodeWorkers := flag.Int("decode-workers", 8, "Number of reactions whose images are loaded at the same time.")
+	diskCacheFlag := flag.Bool("disk-cache", false, "Keep fetched images in the user's cache directory, so they are not downloaded again after a restart.")
+	cacheSize := flag.Int("cache-size", defaultCacheSize, "Number of decoded images to keep cached. The least recently used are freed beyond this. 0 keeps all.")
+	fetchConcurrency := flag.Int("fetch-concurrency", cap(fetchSlots), "Maximum number of concurrent image downloads.")
+	decodeConcurrency := flag.Int("decode-concurrency", cap(decodeSlots), "Maximum number of concurrent image decodes.")
+	twemojiSize := flag.String("twemoji-size", "72", "Size in pixels of the Twemoji PNGs to fetch. The official CDN only has 72, so other sizes need a -twemoji-url mirror. SVG cannot be decoded.")
+	twemojiURLFlag := flag.String("twemoji-url", defaultTwemojiURL, "URL template for Twemoji images, with %s for the code points and {size} for -twemoji-size. Point it at a mirror with larger PNGs for crisper emoji.")
+	flag.BoolVar(&staticOnly, "static", false, "Show only the first frame of animated emojis.")
+	flag.IntVar(&maxCanvasSize, "max-canvas-size", maxCanvasSize, "Reject images that declare a width or height larger than this.")
+	flag.IntVar(&maxImageSize, "max-image-size", maxImageSize, "Downscale images larger than this many pixels before uploading them to the GPU. 0 disables downscaling.")
+	maxAge := flag.Duration("max-age", 0, "Drop queued reactions older than this (e.g. 10s). 0 keeps all reactions.")
+	animSpeed := flag.Float64("anim-speed", 1, "Animation playback speed multiplier (e.g. 0.5 for half speed).")
+	minFPS := flag.Float64("min-fps", 0, "Reduce effects while the frame rate stays below this. 0 disables adaptive quality.")
+	direction := flag.String("direction", "inward", "Spawn direction: inward (edges to center), outward (center to edges) or random.")
+	maxWeight := flag.Float64("max-weight", 1, "Grow reactions repeated within 30 seconds up to this scale multiplier. 1 disables it.")
+	restitution := flag.Float64("restitution", 1, "Share of the speed kept on each bounce (0.0-1.2). Below 1 slows reactions down, above 1 speeds them up.")
+	regionFlag := flag.String("region", "", "Only show reactions inside this rectangle of the window, given as x,y,w,h.")
+	opaqueClear := flag.Bool("opaque-clear", false, "Clear the window to opaque black instead of transparent, for setups where the transparent window flickers.")
+	noPassthrough := flag.Bool("no-passthrough", false, "Let the window receive mouse clicks instead of passing them through.")
+	wobble := flag.Float64("wobble", 0, "Make reactions meander sideways by up to this many pixels. 0 disables it.")
+	lifetimeFade := flag.Bool("lifetime-fade", false, "Fade reactions in after they appear and out over the last second before they disappear.")
+	drawOrder := flag.String("draw-order", "newest-top", "Which objects are drawn on top where they overlap: newest-top or oldest-top.")
+	fit := flag.String("fit", "none", "How images map into the 72x72 box used for bouncing: contain (letterbox), cover (crop) or none (own size).")
+	entrance := flag.String("entrance", "none", "Entrance animation of new reactions: pop (scale in), fade or none.")
+	motion := flag.String("motion", "bounce", "Reaction motion: bounce (off the window edges) or rain (fall from the top edge).")
+	layout := flag.String("layout", "float", "Reaction layout: float (bounce around) or grid (fill a grid from the top-left corner).")
+	bloom := flag.Bool("bloom", false, "Add a glow around bright parts of the reactions.")
+	bloomStrength := flag.Float64("bloom-strength", 1, "Strength of the -bloom glow.")
+	counter := flag.Bool("counter", false, "Show the uptime and the number of received reactions in the top-left corner.")
+	maxSpawns := flag.Int("max-spawns-per-tick", 1, "Most reactions spawned in a single tick (60 ticks = 1 second), so bursts don't queue up.")
+	combo := flag.Int("combo", 0, "Show a combo with a burst of the emoji when the same reaction arrives this many times in a row, each within -combo-window of the last. 0 disables combos.")
+	comboWindow := flag.Duration("combo-window", 5*time.Second, "Longest gap between repeats of a reaction that keeps a -combo going.")
+	burstSize := flag.Int("burst-size", 0, "Number of recent unique reactions to spawn again when the -burst-key is pressed. 0 disables it.")
+	burstKey := ebiten.KeyF9
+	flag.TextVar(&burstKey, "burst-key", burstKey, "Key that spawns the recent reactions again, e.g. F9 or R.")
+	zeroDelay := flag.Duration("zero-frame-delay", defaultFrameDelay, "Delay of animation frames that declare a delay of 0. Other frames are not affected.")
+	layer := flag.String("layer", "top", "Window layer: top (always on top), normal or background.")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9090).")
+	diag := flag.Bool("diag", false, "Write version, platform, flag, config (with secrets redacted) and monitor information to a diag-*.json file for bug reports and exit. Press F10 while running to write one with cache statistics as well.")
+	validateMocksFlag := flag.Bool("validate-mocks", false, "Fetch and decode the test mode images, print the results and exit.")
+	listMonitorsFlag := flag.Bool("list-monitors", false, "Print the available monitors and exit.")
 	flag.Parse()
 
+	if *listMonitorsFlag {
+		listMonitors()
+		return
+	}
+	if *validateMocksFlag {
+		validateMocks()
+		return
+	}
+	if *diag {
+		if err := writeDiagnostics(*configPath, false); err != nil {
+			log.Fatalf("Failed to write diagnostics: %v", err)
+		}
+		return
+	}
+
+	if *holdFrame != "first" && *holdFrame != "last" {
+		log.Fatalf("Invalid -hold-frame %q: must be first or last", *holdFrame)
+	}
+	if *lifetimeTicks < 0 {
+		log.Fatalf("Invalid -lifetime-ticks %d: must not be negative", *lifetimeTicks)
+	}
+	if *animSpeed < minAnimSpeed || *animSpeed > maxAnimSpeed {
+		clamped := min(max(*animSpeed, minAnimSpeed), maxAnimSpeed)
+		log.Printf("-anim-speed %v is out of range, using %v", *animSpeed, clamped)
+		*animSpeed = clamped
+	}
+	if *restitution < 0 || *restitution > maxRestitution {
+		clamped := min(max(*restitution, 0), maxRestitution)
+		log.Printf("-restitution %v is out of range, using %v", *restitution, clamped)
+		*restitution = clamped
+	}
+	tmpl, err := twemojiTemplate(*twemojiURLFlag, *twemojiSize)
+	if err != nil {
+		log.Fatalf("Invalid Twemoji source: %v", err)
+	}
+	twemojiURL = tmpl
+	if *twemojiURLFlag == defaultTwemojiURL && *twemojiSize != "72" {
+		log.Printf("The official Twemoji CDN only hosts 72x72 PNGs; -twemoji-size %s needs a -twemoji-url mirror", *twemojiSize)
+	}
+	if maxCanvasSize < 1 {
+		log.Fatalf("Invalid -max-canvas-size %d: must be at least 1", maxCanvasSize)
+	}
+	if *cacheSize < 0 {
+		log.Fatalf("Invalid -cache-size %d: must not be negative", *cacheSize)
+	}
+	if *decodeWorkers < 1 {
+		log.Fatalf("Invalid -decode-workers %d: must be at least 1", *decodeWorkers)
+	}
+	if *fetchConcurrency < 1 || *decodeConcurrency < 1 {
+		log.Fatalf("Invalid -fetch-concurrency %d or -decode-concurrency %d: must be at least 1", *fetchConcurrency, *decodeConcurrency)
+	}
+	fetchSlots = make(chan struct{}, *fetchConcurrency)
+	decodeSlots = make(chan struct{}, *decodeConcurrency)
+	if *zeroDelay < time.Millisecond {
+		log.Fatalf("Invalid -zero-frame-delay %v: must be at least 1ms", *zeroDelay)
+	}
+	if *wobble < 0 {
+		log.Fatalf("Invalid -wobble %v: must not be negative", *wobble)
+	}
+	if *maxSpawns < 1 {
+		log.Fatalf("Invalid -max-spawns-per-tick %d: must be at least 1", *maxSpawns)
+	}
+	if *combo < 0 {
+		log.Fatalf("Invalid -combo %d: must not be negative", *combo)
+	}
+	if *comboWindow <= 0 {
+		log.Fatalf("Invalid -combo-window %v: must be positive", *comboWindow)
+	}
+	if *burstSize < 0 {
+		log.Fatalf("Invalid -burst-size %d: must not be negative", *burstSize)
+	}
+	if *minVisibleTicks < 0 {
+		log.Fatalf("Invalid -min-visible-ticks %d: must not be negative", *minVisibleTicks)
+	}
+	if *motion != "bounce" && *motion != "rain" {
+		log.Fatalf("Invalid -motion %q: must be bounce or rain", *motion)
+	}
+	if *drawOrder != "newest-top" && *drawOrder != "oldest-top" {
+		log.Fatalf("Invalid -draw-order %q: must be newest-top or oldest-top", *drawOrder)
+	}
+	webhookAddr, isWebhook := strings.CutPrefix(*source, "http:")
+	if *source != "misskey" && !isWebhook {
+		log.Fatalf("Invalid -source %q: must be misskey or http:ADDR", *source)
+	}
+	if *layout != "float" && *layout != "grid" {
+		log.Fatalf("Invalid -layout %q: must be float or grid", *layout)
+	}
+	entrances := map[string]entranceAnimation{"none": entranceNone, "pop": entrancePop, "fade": entranceFade}
+	entranceAnim, ok := entrances[*entrance]
+	if !ok {
+		log.Fatalf("Invalid -entrance %q: must be pop, fade or none", *entrance)
+	}
+	fits := map[string]imageFit{"none": fitNone, "contain": fitContain, "cover": fitCover}
+	imgFit, ok := fits[*fit]
+	if !ok {
+		log.Fatalf("Invalid -fit %q: must be contain, cover or none", *fit)
+	}
+	directions := map[string]spawnDirection{"inward": directionInward, "outward": directionOutward, "random": directionRandom}
+	spawnDir, ok := directions[*direction]
+	if !ok {
+		log.Fatalf("Invalid -direction %q: must be inward, outward or random", *direction)
+	}
+	if *maxWeight < 1 {
+		log.Fatalf("Invalid -max-weight %v: must be at least 1", *maxWeight)
+	}
+	if *minFPS < 0 {
+		log.Fatalf("Invalid -min-fps %v: must not be negative", *minFPS)
+	}
+	if *dim < 0 || *dim > 1 {
+		log.Fatalf("Invalid -dim %v: must be between 0.0 and 1.0", *dim)
+	}
+	region, err := parseRegion(*regionFlag)
+	if err != nil {
+		log.Fatalf("Invalid -region %q: %v", *regionFlag, err)
+	}
+
 	log.Println("Starting Misskey Reaction Visualizer...")
 
+	if err := setup(*fontPath); err != nil {
+		log.Fatalf("Setup error: %v", err)
+	}
+
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
 	reactionChan := make(chan ReactionInfo, 32)
 
 	if *testMode {
 		go runTestMode(reactionChan)
 	}
 
-	// Load config only if not in test mode
+	// Load config only if not in test or replay mode
 	var cfg *Config
-	var err error
-	if !*testMode {
-		cfg, err = loadConfig()
+	if !*testMode && *replay == "" {
+		cfg, err = loadConfig(*configPath, !isWebhook)
 		if err != nil {
 			log.Fatalf("Configuration error: %v", err)
 		}
 	}
 
 	// Initialize dependencies
-	misskeyClient := NewMisskeyClient(cfg) // cfg can be nil in test mode, which is fine
+	misskeyCfg := cfg
+	if isWebhook && cfg != nil && cfg.MisskeyInstance == "" {
+		misskeyCfg = nil // Custom emojis can only be resolved when an instance is configured
+	}
+	misskeyClient := NewMisskeyClient(misskeyCfg) // cfg can be nil in test mode, which is fine
 	imageManager := NewImageManager(misskeyClient)
+	if *diskCacheFlag {
+		dir, err := defaultDiskCacheDir()
+		if err == nil {
+			imageManager, err = NewImageManagerWithCacheDir(misskeyClient, dir)
+		}
+		if err != nil {
+			log.Fatalf("Disk cache error: %v", err)
+		}
+	}
+	imageManager.cacheSize = *cacheSize
+	var effects map[string]objectEffect
+	if cfg != nil {
+		imageManager.mediaProxy = cfg.MediaProxy
+		effects = make(map[string]objectEffect, len(cfg.Effects))
+		for name, effect := range cfg.Effects {
+			effects[name] = objectEffects[effect]
+		}
+		for _, format := range cfg.StaticFormats {
+			staticFormats[format] = true
+		}
+	}
+	if cfg != nil && cfg.FallbackImage != "" {
+		if err := imageManager.LoadFallbackImage(cfg.FallbackImage); err != nil {
+			log.Fatalf("Configuration error: %v", err)
+		}
+	}
+	imageManager.StartWorkers(*decodeWorkers)
 
-	if !*testMode {
-		go misskeyClient.Connect(reactionChan)
+	if !*testMode && *replay == "" && isWebhook {
+		if err := serveWebhook(webhookAddr, cfg.WebhookToken, reactionChan); err != nil {
+			log.Fatalf("Webhook error: %v", err)
+		}
+	} else if !*testMode && *replay == "" {
+		if err := misskeyClient.CheckToken(); err != nil {
+			log.Fatalf("Access token check failed: %v", err)
+		}
+		if err := misskeyClient.LoadEmojis(); err != nil {
+			log.Printf("Failed to load the emoji list, querying emojis one by one: %v", err)
+		}
+		go misskeyClient.Connect(context.Background(), reactionChan)
 	}
 
-	ebiten.SetWindowDecorated(false)
-	ebiten.SetWindowFloating(true)
-	ebiten.SetWindowMousePassthrough(true)
-	ebiten.SetWindowTitle("Misskey Reactions")
+	setupWindow(*layer, !*noPassthrough)
+	// Game.Draw clears the screen itself.
+	ebiten.SetScreenClearedEveryFrame(false)
 	screenWidth, screenHeight := ebiten.Monitor().Size()
 	s := ebiten.Monitor().DeviceScaleFactor()
 	ebiten.SetWindowSize(int(float64(screenWidth)*s), int(float64(screenHeight)*s)-1)
 
 	// Inject dependencies into the game
 	game := NewGame(reactionChan, imageManager)
+	game.randomFlip = *randomFlip
+	game.playOnce = *playOnce
+	game.holdFirst = *holdFrame == "first"
+	game.trail = *trail
+	game.lifetime = *lifetimeTicks
+	game.labels = *labels
+	game.dim = *dim
+	game.maxAge = *maxAge
+	game.animSpeed = *animSpeed
+	game.zeroDelay = *zeroDelay
+	game.minVisibleTicks = *minVisibleTicks
+	game.region = region
+	game.direction = spawnDir
+	game.maxWeight = *maxWeight
+	game.restitution = *restitution
+	game.opaqueClear = *opaqueClear
+	game.grid = *layout == "grid"
+	game.rain = *motion == "rain"
+	game.entrance = entranceAnim
+	game.fit = imgFit
+	game.oldestOnTop = *drawOrder == "oldest-top"
+	game.lifetimeFade = *lifetimeFade
+	game.effects = effects
+	game.wobble = *wobble
+	game.counter = *counter
+	game.configPath = *configPath
+	game.burstSize = *burstSize
+	game.comboThreshold = *combo
+	game.maxSpawnsPerTick = *maxSpawns
+	game.comboWindow = *comboWindow
+	game.burstKey = burstKey
+	if *bloom {
+		game.bloom = *bloomStrength
+	}
+	if *replay != "" {
+		if err := game.loadSnapshot(*replay); err != nil {
+			log.Fatalf("Replay error: %v", err)
+		}
+	}
+	game.quality.minFPS = *minFPS
 
 	opts := ebiten.RunGameOptions{ScreenTransparent: true}
 	if err := ebiten.RunGameWithOptions(game, &opts); err != nil {
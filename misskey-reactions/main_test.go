@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupFont(t *testing.T) {
+	dir := t.TempDir()
+	notAFont := filepath.Join(dir, "font.ttf")
+	if err := os.WriteFile(notAFont, []byte("not a font"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"", ""},
+		{filepath.Join(dir, "missing.ttf"), "cannot read font"},
+		{notAFont, "cannot load font"},
+	}
+	for _, tt := range tests {
+		err := setup(tt.path)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("setup(%q) = %v, want nil", tt.path, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("setup(%q) = %v, want %q", tt.path, err, tt.wantErr)
+		}
+	}
+}
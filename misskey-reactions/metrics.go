@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counter is a monotonically increasing Prometheus counter.
+type counter struct {
+	v atomic.Uint64
+}
+
+func (c *counter) Inc() { c.v.Add(1) }
+
+// Value returns the current count.
+func (c *counter) Value() uint64 { return c.v.Load() }
+
+// gauge is a Prometheus gauge holding an integer value.
+type gauge struct {
+	v atomic.Int64
+}
+
+func (g *gauge) Set(v int) { g.v.Store(int64(v)) }
+
+// Value returns the current value.
+func (g *gauge) Value() int64 { return g.v.Load() }
+
+// histogram is a Prometheus histogram with fixed upper bounds.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+// Observe records a single value.
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// appMetrics holds all instrumentation exposed on the metrics endpoint.
+type appMetrics struct {
+	reactionsReceived counter
+	reactionsSpawned  counter
+	reactionsDropped  counter
+	fetchSuccess      counter
+	fetchFailure      counter
+	cacheSize         gauge
+	activeObjects     gauge
+	decodeDuration    *histogram
+}
+
+var metrics = &appMetrics{
+	decodeDuration: newHistogram(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5),
+}
+
+// write writes all metrics in the Prometheus text exposition format.
+func (m *appMetrics) write(w io.Writer) {
+	writeCounter(w, "mifloat_reactions_received_total", "Reactions received from the source.", &m.reactionsReceived)
+	writeCounter(w, "mifloat_reactions_spawned_total", "Reactions spawned as on-screen objects.", &m.reactionsSpawned)
+	writeCounter(w, "mifloat_reactions_dropped_total", "Reactions dropped without being spawned, because the object limit was reached, they were older than -max-age or the window was empty.", &m.reactionsDropped)
+	writeCounter(w, "mifloat_image_fetch_success_total", "Successful image fetches.", &m.fetchSuccess)
+	writeCounter(w, "mifloat_image_fetch_failure_total", "Failed image fetches.", &m.fetchFailure)
+	writeGauge(w, "mifloat_image_cache_entries", "Number of images in the cache.", &m.cacheSize)
+	writeGauge(w, "mifloat_active_objects", "Number of reaction objects on screen.", &m.activeObjects)
+
+	h := m.decodeDuration
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	const name = "mifloat_image_decode_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time spent decoding fetched images.\n# TYPE %s histogram\n", name, name)
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+func writeCounter(w io.Writer, name, help string, c *counter) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, c.v.Load())
+}
+
+func writeGauge(w io.Writer, name, help string, g *gauge) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, g.v.Load())
+}
+
+// observeDecode records the time elapsed since start in the decode histogram.
+func observeDecode(start time.Time) {
+	metrics.decodeDuration.Observe(time.Since(start).Seconds())
+}
+
+// serveMetrics starts the Prometheus metrics endpoint on addr in the background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w)
+	})
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
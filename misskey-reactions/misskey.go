@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,13 +21,21 @@ import (
 // MisskeyAPI defines the interface for interacting with Misskey.
 // This allows for mocking in tests.
 type MisskeyAPI interface {
-	Connect(reactionChan chan<- ReactionInfo)
+	Connect(ctx context.Context, reactionChan chan<- ReactionInfo)
 	QueryEmojiAPI(emojiName string) (string, error)
+	HasEmoji(emojiName string) bool
+	RefreshEmojiURL(emojiName string) (string, error)
+	MediaToken(mediaURL string) string
 }
 
 // MisskeyClient handles all communication with the Misskey API and WebSocket.
 type MisskeyClient struct {
 	config *Config
+
+	listeners      []func(ConnectionEvent)
+	listenersMutex sync.RWMutex
+
+	emojis map[string]string // Custom emoji name to URL, filled once by LoadEmojis
 }
 
 // Statically check that *MisskeyClient implements MisskeyAPI.
@@ -34,6 +46,61 @@ func NewMisskeyClient(cfg *Config) *MisskeyClient {
 	return &MisskeyClient{config: cfg}
 }
 
+// ConnectionState describes a stage of the streaming connection lifecycle.
+type ConnectionState int
+
+// Connection lifecycle states reported to listeners registered with OnConnectionEvent.
+const (
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateMessageReceived
+	StateDisconnected
+	StateReconnecting
+)
+
+// String returns the state name used in logs.
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateMessageReceived:
+		return "message-received"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return fmt.Sprintf("ConnectionState(%d)", int(s))
+	}
+}
+
+// ConnectionEvent is delivered to listeners whenever the connection state changes.
+// Err is set for StateDisconnected when the connection was lost due to an error.
+type ConnectionEvent struct {
+	State ConnectionState
+	Err   error
+}
+
+// OnConnectionEvent registers a listener for connection lifecycle events.
+// Listeners are called synchronously from the connection goroutine, so they
+// should return quickly.
+func (mc *MisskeyClient) OnConnectionEvent(fn func(ConnectionEvent)) {
+	mc.listenersMutex.Lock()
+	defer mc.listenersMutex.Unlock()
+	mc.listeners = append(mc.listeners, fn)
+}
+
+// emit notifies all registered listeners of a connection event.
+func (mc *MisskeyClient) emit(state ConnectionState, err error) {
+	mc.listenersMutex.RLock()
+	defer mc.listenersMutex.RUnlock()
+	for _, fn := range mc.listeners {
+		fn(ConnectionEvent{State: state, Err: err})
+	}
+}
+
 // MisskeyStreamMessage defines the structure for incoming WebSocket messages.
 type MisskeyStreamMessage struct {
 	Type string `json:"type"`
@@ -49,59 +116,279 @@ type NotificationBody struct {
 	Type     string `json:"type"`
 	Reaction string `json:"reaction"`
 	Note     struct {
+		ID             string            `json:"id"`
+		Reactions      map[string]int    `json:"reactions"`
 		ReactionEmojis map[string]string `json:"reactionEmojis"`
 	} `json:"note"`
 }
 
 // ReactionInfo holds the name and optional URL of a reaction.
 type ReactionInfo struct {
-	Name string
-	URL  string
+	Name       string
+	URL        string
+	ReceivedAt time.Time // When the reaction arrived from the source
+
+	// Group holds the other reactions currently on the same note. It is only
+	// populated when note grouping is enabled in the config.
+	Group []ReactionInfo
+
+	// Removed is set when the reaction was taken back from a note rather than added.
+	Removed bool
+
+	// LifetimeTicks is how long the source wants the reaction displayed, in ticks.
+	// 0 uses the game's default lifetime.
+	LifetimeTicks int
+}
+
+// UnreactedBody is the body of a noteUpdated event of type "unreacted".
+type UnreactedBody struct {
+	Reaction string `json:"reaction"`
+}
+
+// maxSubscribedNotes limits how many notes are watched for reaction removals.
+const maxSubscribedNotes = 100
+
+// newReactionInfo builds a ReactionInfo, resolving custom emoji URLs from the
+// note's reactionEmojis map.
+func newReactionInfo(name string, reactionEmojis map[string]string) ReactionInfo {
+	reaction := ReactionInfo{Name: name}
+	if url, ok := reactionEmojis[strings.Trim(name, ":")]; ok {
+		reaction.URL = url
+	}
+	return reaction
 }
 
-// Connect establishes a WebSocket connection and listens for reactions.
-func (mc *MisskeyClient) Connect(reactionChan chan<- ReactionInfo) {
+// streamDialer opens the streaming connection. It is a variable so tests can
+// connect to a local TLS server.
+var streamDialer = websocket.DefaultDialer
+
+// reconnectDelay is how long Connect waits before connecting again.
+var reconnectDelay = 5 * time.Second
+
+// Connect establishes a WebSocket connection and listens for reactions,
+// reconnecting in place whenever the connection is lost. It returns once ctx
+// is done.
+func (mc *MisskeyClient) Connect(ctx context.Context, reactionChan chan<- ReactionInfo) {
+	for {
+		err := mc.listen(ctx, reactionChan)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Connection error: %v. Reconnecting...", err)
+		mc.emit(StateDisconnected, err)
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+		mc.emit(StateReconnecting, nil)
+	}
+}
+
+// listen connects once and forwards reactions until connecting or reading from
+// the connection fails, or ctx is done. The connection is closed before the
+// error is returned.
+func (mc *MisskeyClient) listen(ctx context.Context, reactionChan chan<- ReactionInfo) error {
 	u := url.URL{Scheme: "wss", Host: mc.config.MisskeyInstance, Path: "/streaming", RawQuery: "i=" + mc.config.AccessToken}
 	log.Printf("Connecting to %s", u.String())
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	mc.emit(StateConnecting, nil)
+	c, _, err := streamDialer.DialContext(ctx, u.String(), nil)
 	if err != nil {
-		log.Fatalf("Failed to connect: %v", err)
+		return fmt.Errorf("connect: %w", err)
 	}
 	defer c.Close()
+	// Closing the connection unblocks ReadJSON once ctx is done.
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
 	channelID := uuid.New().String()
 	connectMsg := map[string]interface{}{"type": "connect", "body": map[string]interface{}{"channel": "main", "id": channelID}}
 	if err := c.WriteJSON(connectMsg); err != nil {
-		log.Fatalf("Failed to subscribe: %v", err)
+		return fmt.Errorf("subscribe: %w", err)
 	}
 	log.Println("Successfully connected and subscribed.")
+	mc.emit(StateConnected, nil)
+	var subscribedNotes []string // Oldest first
 	for {
 		var msg MisskeyStreamMessage
 		if err := c.ReadJSON(&msg); err != nil {
-			log.Printf("Read error: %v. Reconnecting...", err)
-			time.Sleep(5 * time.Second)
-			go mc.Connect(reactionChan) // Reconnect using the method
-			return
+			return err
 		}
+		mc.emit(StateMessageReceived, nil)
 		if msg.Type == "channel" && msg.Body.Type == "notification" {
 			var n NotificationBody
 			if err := json.Unmarshal(msg.Body.Body, &n); err == nil && n.Type == "reaction" && n.Reaction != "" {
-				reaction := ReactionInfo{Name: n.Reaction}
-				if url, ok := n.Note.ReactionEmojis[strings.Trim(n.Reaction, ":")]; ok {
-					reaction.URL = url
+				reaction := newReactionInfo(n.Reaction, n.Note.ReactionEmojis)
+				reaction.ReceivedAt = time.Now()
+				if mc.config.GroupNoteReactions {
+					for _, name := range slices.Sorted(maps.Keys(n.Note.Reactions)) {
+						if name != n.Reaction {
+							reaction.Group = append(reaction.Group, newReactionInfo(name, n.Note.ReactionEmojis))
+						}
+					}
+				}
+				select {
+				case reactionChan <- reaction:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				if mc.config.TrackReactionRemoval && n.Note.ID != "" && !slices.Contains(subscribedNotes, n.Note.ID) {
+					subscribedNotes = mc.subscribeNote(c, subscribedNotes, n.Note.ID)
+				}
+			}
+		} else if msg.Type == "noteUpdated" && msg.Body.Type == "unreacted" {
+			var u UnreactedBody
+			if err := json.Unmarshal(msg.Body.Body, &u); err == nil && u.Reaction != "" {
+				select {
+				case reactionChan <- ReactionInfo{Name: u.Reaction, ReceivedAt: time.Now(), Removed: true}:
+				case <-ctx.Done():
+					return ctx.Err()
 				}
-				reactionChan <- reaction
 			}
 		}
 	}
 }
 
+// subscribeNote watches a note for updates so reaction removals can be reported.
+// The oldest subscription is dropped once maxSubscribedNotes is reached.
+func (mc *MisskeyClient) subscribeNote(c *websocket.Conn, subscribed []string, noteID string) []string {
+	if len(subscribed) >= maxSubscribedNotes {
+		unsubMsg := map[string]interface{}{"type": "unsubNote", "body": map[string]interface{}{"id": subscribed[0]}}
+		if err := c.WriteJSON(unsubMsg); err != nil {
+			log.Printf("Failed to unsubscribe from note %s: %v", subscribed[0], err)
+		}
+		subscribed = subscribed[1:]
+	}
+	subMsg := map[string]interface{}{"type": "subNote", "body": map[string]interface{}{"id": noteID}}
+	if err := c.WriteJSON(subMsg); err != nil {
+		log.Printf("Failed to subscribe to note %s: %v", noteID, err)
+		return subscribed
+	}
+	return append(subscribed, noteID)
+}
+
+// CheckToken verifies that the access token is valid and can read notifications,
+// which is where reactions arrive. Without this, a token missing the permission
+// connects fine but never shows anything.
+func (mc *MisskeyClient) CheckToken() error {
+	if mc.config == nil {
+		return fmt.Errorf("misskey client config not loaded")
+	}
+	resp, err := mc.post("/api/i", map[string]any{})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("access token is invalid or expired")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("i API returned status: %s", resp.Status)
+	}
+
+	resp, err = mc.post("/api/i/notifications", map[string]any{"limit": 1})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("access token lacks read:notifications; create a token with the \"Read notifications\" permission")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notifications API returned status: %s", resp.Status)
+	}
+	return nil
+}
+
+// post sends an authenticated API request to the instance. The caller closes the body.
+func (mc *MisskeyClient) post(path string, payload map[string]any) (*http.Response, error) {
+	payload["i"] = mc.config.AccessToken
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return http.Post("https://"+mc.config.MisskeyInstance+path, "application/json", bytes.NewBuffer(jsonPayload))
+}
+
 // EmojiAPIResponse is the structure for the emoji API response.
 type EmojiAPIResponse struct {
 	URL string `json:"url"`
 }
 
-// QueryEmojiAPI fetches a custom emoji URL from the instance API.
+// LoadEmojis fetches the instance's full custom emoji list so QueryEmojiAPI can
+// resolve most emojis without a request each. The response is decoded one entry
+// at a time, since instances can have tens of thousands of emojis. It must be
+// called before the client is shared with other goroutines.
+func (mc *MisskeyClient) LoadEmojis() error {
+	if mc.config == nil {
+		return fmt.Errorf("misskey client config not loaded")
+	}
+	resp, err := http.Get(fmt.Sprintf("https://%s/api/emojis", mc.config.MisskeyInstance))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("emojis API returned status: %s", resp.Status)
+	}
+
+	// The response is {"emojis": [{"name": ..., "url": ...}, ...]}.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("emojis API response has no emoji list: %w", err)
+		}
+		if t == "emojis" {
+			break
+		}
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return fmt.Errorf("emojis API response has no emoji list")
+	}
+	emojis := make(map[string]string)
+	for dec.More() {
+		var e struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		}
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		if e.Name != "" && e.URL != "" {
+			emojis[e.Name] = e.URL
+		}
+	}
+	mc.emojis = emojis
+	log.Printf("Loaded %d custom emojis", len(emojis))
+	return nil
+}
+
+// QueryEmojiAPI returns a custom emoji URL, looking it up in the list loaded by
+// LoadEmojis first and falling back to the per-emoji instance API. Local
+// emojis in reactions are named "name@.", but are listed as "name".
 func (mc *MisskeyClient) QueryEmojiAPI(emojiName string) (string, error) {
+	if mc.config == nil {
+		return "", fmt.Errorf("misskey client config not loaded")
+	}
+	emojiName = strings.TrimSuffix(emojiName, "@.")
+	if url, ok := mc.emojis[emojiName]; ok {
+		return url, nil
+	}
+	return mc.RefreshEmojiURL(emojiName)
+}
+
+// HasEmoji reports whether the instance defines a custom emoji named emojiName,
+// according to the list loaded by LoadEmojis.
+func (mc *MisskeyClient) HasEmoji(emojiName string) bool {
+	_, ok := mc.emojis[strings.TrimSuffix(emojiName, "@.")]
+	return ok
+}
+
+// RefreshEmojiURL fetches a custom emoji URL from the per-emoji instance API,
+// bypassing the list loaded by LoadEmojis. It is used to replace expired URLs.
+func (mc *MisskeyClient) RefreshEmojiURL(emojiName string) (string, error) {
 	if mc.config == nil {
 		return "", fmt.Errorf("misskey client config not loaded")
 	}
@@ -133,3 +420,17 @@ func (mc *MisskeyClient) QueryEmojiAPI(emojiName string) (string, error) {
 
 	return apiResp.URL, nil
 }
+
+// MediaToken returns the access token to send when fetching mediaURL, or an empty
+// string if the URL is not served over HTTPS by the configured instance. This keeps
+// the token from leaking to third-party hosts such as the Twemoji CDN.
+func (mc *MisskeyClient) MediaToken(mediaURL string) string {
+	if mc.config == nil {
+		return ""
+	}
+	u, err := url.Parse(mediaURL)
+	if err != nil || u.Scheme != "https" || !strings.EqualFold(u.Host, mc.config.MisskeyInstance) {
+		return ""
+	}
+	return mc.config.AccessToken
+}
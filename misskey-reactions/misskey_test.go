@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestConnectReconnects drops every connection right after it is made, and
+// rejects every other handshake, so Connect has to keep reconnecting. It must
+// not exit on a failed dial, nor leave goroutines behind on each reconnect.
+func TestConnectReconnects(t *testing.T) {
+	var attempts atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1)%2 == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var msg map[string]any
+		c.ReadJSON(&msg) // The channel subscription
+		c.Close()
+	}))
+	t.Cleanup(srv.Close)
+
+	oldDialer, oldDelay := streamDialer, reconnectDelay
+	streamDialer = &websocket.Dialer{TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+	reconnectDelay = time.Millisecond
+	t.Cleanup(func() { streamDialer, reconnectDelay = oldDialer, oldDelay })
+
+	mc := NewMisskeyClient(&Config{MisskeyInstance: strings.TrimPrefix(srv.URL, "https://"), AccessToken: "token"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mc.Connect(ctx, make(chan ReactionInfo))
+		close(done)
+	}()
+	// Cleanups run last first, so Connect stops before the globals are restored.
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitForAttempts := func(n int32) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for attempts.Load() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("only %d connection attempts, want %d", attempts.Load(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForAttempts(5)
+	before := runtime.NumGoroutine()
+	waitForAttempts(30)
+	const tolerance = 5
+	if after := runtime.NumGoroutine(); after > before+tolerance {
+		t.Errorf("goroutines grew from %d to %d over 25 reconnects", before, after)
+	}
+}
+
+func TestQueryEmojiAPIUsesLoadedList(t *testing.T) {
+	// The instance is unreachable, so only the loaded list can answer.
+	mc := NewMisskeyClient(&Config{MisskeyInstance: "invalid.invalid"})
+	mc.emojis = map[string]string{"blobcat": "https://example.com/blobcat.png"}
+	for _, reaction := range []string{":blobcat:", ":blobcat@.:"} {
+		name := strings.Trim(reaction, ":") // As LoadImageForObject passes it
+		url, err := mc.QueryEmojiAPI(name)
+		if err != nil || url != "https://example.com/blobcat.png" {
+			t.Errorf("QueryEmojiAPI(%q) = %q, %v, want the listed URL", name, url, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Quality levels, from all effects enabled to the cheapest rendering.
+// Each level also disables everything the levels before it did.
+const (
+	qualityFull         = iota
+	qualityNoTrails     // Skip the afterimage
+	qualityNoLabels     // Skip labels and their shadows
+	qualityFewerObjects // Halve the number of objects on screen
+)
+
+const (
+	qualityLowerTicks = 120 // Ticks the frame rate must stay low before lowering the level
+	qualityRaiseTicks = 600 // Ticks the frame rate must stay healthy before raising it again
+)
+
+// actualFPS reports the measured frame rate. It is a variable so the controller
+// can be driven without running the game loop.
+var actualFPS = ebiten.ActualFPS
+
+// qualityController lowers the quality level while the frame rate stays below
+// minFPS and restores it step by step once the frame rate recovers.
+type qualityController struct {
+	minFPS float64 // 0 disables adaptive quality
+	level  int
+	ticks  int // Ticks the frame rate has been on the same side of minFPS
+	slow   bool
+}
+
+// update samples the current frame rate and adjusts the level. It is called once per tick.
+func (q *qualityController) update() {
+	if q.minFPS <= 0 {
+		return
+	}
+	slow := actualFPS() < q.minFPS
+	if slow != q.slow {
+		q.slow, q.ticks = slow, 0
+	}
+	q.ticks++
+
+	switch {
+	case slow && q.ticks >= qualityLowerTicks && q.level < qualityFewerObjects:
+		q.level++
+		q.ticks = 0
+		log.Printf("Frame rate below %v, lowering quality to level %d", q.minFPS, q.level)
+	case !slow && q.ticks >= qualityRaiseTicks && q.level > qualityFull:
+		q.level--
+		q.ticks = 0
+		log.Printf("Frame rate recovered, raising quality to level %d", q.level)
+	}
+}
+
+// trails reports whether afterimages should be drawn.
+func (q *qualityController) trails() bool { return q.level < qualityNoTrails }
+
+// labels reports whether labels should be drawn.
+func (q *qualityController) labels() bool { return q.level < qualityNoLabels }
+
+// objectLimit returns the maximum number of objects allowed on screen.
+func (q *qualityController) objectLimit() int {
+	if q.level >= qualityFewerObjects {
+		return maxObjects / 2
+	}
+	return maxObjects
+}
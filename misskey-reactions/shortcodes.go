@@ -0,0 +1,203 @@
+package main
+
+// shortcodeEmojis maps common shortcodes of standard emoji, as used by GitHub
+// and Slack (gemoji), to the emoji itself. Reactions named like this are drawn
+// from Twemoji without asking the instance whether such a custom emoji exists.
+var shortcodeEmojis = map[string]string{
+	// Smileys
+	"smile":                          "😄",
+	"smiley":                         "😃",
+	"grinning":                       "😀",
+	"grin":                           "😁",
+	"laughing":                       "😆",
+	"sweat_smile":                    "😅",
+	"joy":                            "😂",
+	"rofl":                           "🤣",
+	"slightly_smiling_face":          "🙂",
+	"upside_down_face":               "🙃",
+	"wink":                           "😉",
+	"blush":                          "😊",
+	"innocent":                       "😇",
+	"smiling_face_with_three_hearts": "🥰",
+	"heart_eyes":                     "😍",
+	"star_struck":                    "🤩",
+	"kissing_heart":                  "😘",
+	"yum":                            "😋",
+	"stuck_out_tongue":               "😛",
+	"stuck_out_tongue_winking_eye":   "😜",
+	"zany_face":                      "🤪",
+	"hugs":                           "🤗",
+	"thinking":                       "🤔",
+	"shushing_face":                  "🤫",
+	"neutral_face":                   "😐",
+	"expressionless":                 "😑",
+	"no_mouth":                       "😶",
+	"smirk":                          "😏",
+	"unamused":                       "😒",
+	"roll_eyes":                      "🙄",
+	"grimacing":                      "😬",
+	"relieved":                       "😌",
+	"pensive":                        "😔",
+	"sleepy":                         "😪",
+	"sleeping":                       "😴",
+	"mask":                           "😷",
+	"nauseated_face":                 "🤢",
+	"exploding_head":                 "🤯",
+	"cowboy_hat_face":                "🤠",
+	"partying_face":                  "🥳",
+	"sunglasses":                     "😎",
+	"nerd_face":                      "🤓",
+	"confused":                       "😕",
+	"worried":                        "😟",
+	"open_mouth":                     "😮",
+	"astonished":                     "😲",
+	"flushed":                        "😳",
+	"pleading_face":                  "🥺",
+	"fearful":                        "😨",
+	"cold_sweat":                     "😰",
+	"cry":                            "😢",
+	"sob":                            "😭",
+	"scream":                         "😱",
+	"confounded":                     "😖",
+	"persevere":                      "😣",
+	"disappointed":                   "😞",
+	"sweat":                          "😓",
+	"weary":                          "😩",
+	"tired_face":                     "😫",
+	"yawning_face":                   "🥱",
+	"triumph":                        "😤",
+	"rage":                           "😡",
+	"angry":                          "😠",
+	"skull":                          "💀",
+	"poop":                           "💩",
+	"clown_face":                     "🤡",
+	"ghost":                          "👻",
+	"alien":                          "👽",
+	"robot":                          "🤖",
+	"smiley_cat":                     "😺",
+	"see_no_evil":                    "🙈",
+
+	// Hearts and symbols
+	"heart":            "❤️",
+	"orange_heart":     "🧡",
+	"yellow_heart":     "💛",
+	"green_heart":      "💚",
+	"blue_heart":       "💙",
+	"purple_heart":     "💜",
+	"black_heart":      "🖤",
+	"white_heart":      "🤍",
+	"broken_heart":     "💔",
+	"two_hearts":       "💕",
+	"sparkling_heart":  "💖",
+	"heartpulse":       "💗",
+	"revolving_hearts": "💞",
+	"100":              "💯",
+	"anger":            "💢",
+	"boom":             "💥",
+	"dizzy":            "💫",
+	"sweat_drops":      "💦",
+	"zzz":              "💤",
+	"fire":             "🔥",
+	"sparkles":         "✨",
+	"star":             "⭐",
+	"star2":            "🌟",
+	"tada":             "🎉",
+	"confetti_ball":    "🎊",
+	"bulb":             "💡",
+	"warning":          "⚠️",
+	"white_check_mark": "✅",
+	"heavy_check_mark": "✔️",
+	"x":                "❌",
+	"question":         "❓",
+	"exclamation":      "❗",
+	"bangbang":         "‼️",
+	"interrobang":      "⁉️",
+	"ok":               "🆗",
+	"new":              "🆕",
+	"up":               "🆙",
+	"cool":             "🆒",
+
+	// People and gestures
+	"+1":              "👍",
+	"thumbsup":        "👍",
+	"-1":              "👎",
+	"thumbsdown":      "👎",
+	"ok_hand":         "👌",
+	"v":               "✌️",
+	"crossed_fingers": "🤞",
+	"metal":           "🤘",
+	"call_me_hand":    "🤙",
+	"wave":            "👋",
+	"raised_hand":     "✋",
+	"clap":            "👏",
+	"raised_hands":    "🙌",
+	"open_hands":      "👐",
+	"handshake":       "🤝",
+	"pray":            "🙏",
+	"muscle":          "💪",
+	"point_up":        "☝️",
+	"point_right":     "👉",
+	"point_left":      "👈",
+	"eyes":            "👀",
+	"bow":             "🙇",
+	"facepalm":        "🤦",
+	"shrug":           "🤷",
+	"ok_woman":        "🙆",
+	"no_good":         "🙅",
+
+	// Nature, food and objects
+	"cat":            "🐱",
+	"dog":            "🐶",
+	"rabbit":         "🐰",
+	"bear":           "🐻",
+	"panda_face":     "🐼",
+	"penguin":        "🐧",
+	"bird":           "🐦",
+	"fish":           "🐟",
+	"bug":            "🐛",
+	"cherry_blossom": "🌸",
+	"sunflower":      "🌻",
+	"seedling":       "🌱",
+	"sunny":          "☀️",
+	"cloud":          "☁️",
+	"umbrella":       "☔",
+	"snowflake":      "❄️",
+	"zap":            "⚡",
+	"rainbow":        "🌈",
+	"crescent_moon":  "🌙",
+	"apple":          "🍎",
+	"tangerine":      "🍊",
+	"strawberry":     "🍓",
+	"pizza":          "🍕",
+	"hamburger":      "🍔",
+	"sushi":          "🍣",
+	"ramen":          "🍜",
+	"rice_ball":      "🍙",
+	"cake":           "🍰",
+	"birthday":       "🎂",
+	"cookie":         "🍪",
+	"coffee":         "☕",
+	"tea":            "🍵",
+	"beer":           "🍺",
+	"beers":          "🍻",
+	"wine_glass":     "🍷",
+	"gift":           "🎁",
+	"trophy":         "🏆",
+	"medal_sports":   "🏅",
+	"rocket":         "🚀",
+	"computer":       "💻",
+	"iphone":         "📱",
+	"books":          "📚",
+	"memo":           "📝",
+	"pushpin":        "📌",
+	"mag":            "🔍",
+	"lock":           "🔒",
+	"key":            "🔑",
+	"bell":           "🔔",
+	"musical_note":   "🎵",
+	"notes":          "🎶",
+	"video_game":     "🎮",
+	"moneybag":       "💰",
+	"hourglass":      "⌛",
+	"alarm_clock":    "⏰",
+}
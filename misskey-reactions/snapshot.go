@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// objectSnapshot is the saved state of a single ReactionObject.
+type objectSnapshot struct {
+	Name     string  `json:"name"`
+	URL      string  `json:"url,omitempty"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	VX       float64 `json:"vx"`
+	VY       float64 `json:"vy"`
+	Scale    float64 `json:"scale"`
+	Flipped  bool    `json:"flipped,omitempty"`
+	Frame    int     `json:"frame"`
+	Lifetime int     `json:"lifetime"`
+}
+
+// saveSnapshot writes the objects currently on screen to a timestamped JSON file
+// in the working directory, so a scene can be attached to a bug report.
+// Group members are saved as independent objects.
+func (g *Game) saveSnapshot() error {
+	snapshot := make([]objectSnapshot, 0, len(g.objects))
+	for _, o := range g.objects {
+		snapshot = append(snapshot, objectSnapshot{
+			Name:     o.reactionName,
+			URL:      o.reactionURL,
+			X:        o.x,
+			Y:        o.y,
+			VX:       o.vx,
+			VY:       o.vy,
+			Scale:    o.scale,
+			Flipped:  o.flipped,
+			Frame:    o.currentFrame,
+			Lifetime: o.lifetime,
+		})
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("snapshot-%s.json", time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("Saved %d objects to %s", len(snapshot), path)
+	return nil
+}
+
+// loadSnapshot recreates the objects saved by saveSnapshot. Their images are
+// resolved again by name and URL, so no live connection is needed. Objects
+// beyond the object limit are dropped, as they would be when spawned.
+func (g *Game) loadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read snapshot: %w", err)
+	}
+	var snapshot []objectSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	for i, s := range snapshot {
+		if len(g.objects) >= g.quality.objectLimit() {
+			log.Printf("Snapshot has more than %d objects, skipping the last %d", g.quality.objectLimit(), len(snapshot)-i)
+			break
+		}
+		obj := g.newObject(s.Name, s.Scale, s.Lifetime)
+		obj.x, obj.y = s.X, s.Y
+		obj.vx, obj.vy = s.VX, s.VY
+		obj.flipped = s.Flipped
+		obj.currentFrame = s.Frame
+		g.addObject(obj, ReactionInfo{Name: s.Name, URL: s.URL})
+	}
+	return nil
+}
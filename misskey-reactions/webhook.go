@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// maxWebhookBody is the largest request body accepted by the webhook.
+const maxWebhookBody = 64 << 10
+
+// webhookReaction is the JSON body posted to the webhook.
+type webhookReaction struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// serveWebhook accepts reactions POSTed as JSON to addr and sends them to
+// reactionChan, so other systems can feed the overlay instead of Misskey. When
+// token is set, requests must carry it as "Authorization: Bearer <token>".
+func serveWebhook(addr, token string, reactionChan chan<- ReactionInfo) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body webhookReaction
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBody)).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if body.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		select {
+		case reactionChan <- ReactionInfo{Name: body.Name, URL: body.URL, ReceivedAt: time.Now()}:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "too many reactions queued", http.StatusServiceUnavailable)
+		}
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen for webhooks: %w", err)
+	}
+	go func() {
+		log.Printf("Accepting reactions on http://%s/", ln.Addr())
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("Webhook server stopped: %v", err)
+		}
+	}()
+	return nil
+}